@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const headerAPIKey = "X-API-Key"
+
+// apiKeyLastUsedPrecision limits writes of API key last used timestamps to one per interval.
+const apiKeyLastUsedPrecision = time.Minute
+
+// apiKeySubjectPrefix prefixes key id in the subject of claims of API key callers.
+const apiKeySubjectPrefix = "apikey:"
+
+// APIKey is a long-lived credential of a service client. Only SHA-256 hash of the key is stored,
+// the key itself is returned once by create and rotate.
+type APIKey struct {
+	Id   primitive.ObjectID `json:"id" bson:"_id"`
+	Name string             `json:"name" bson:"name"`
+	Hash string             `json:"-" bson:"hash"`
+	// Scopes are roles granted to the key, see RoleReader, RoleWriter and RoleAdmin.
+	Scopes []string `json:"scopes" bson:"scopes"`
+	// RateLimit is the max number of requests per minute, 0 means no limit.
+	RateLimit  int        `json:"rateLimit,omitempty" bson:"rateLimit,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt" bson:"createdAt"`
+	RotatedAt  *time.Time `json:"rotatedAt,omitempty" bson:"rotatedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty" bson:"revokedAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty" bson:"lastUsedAt,omitempty"`
+}
+
+// IssuedAPIKey is the response of create and rotate, Key is not stored and can't be shown again.
+type IssuedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// APIKeyStore keeps API keys.
+type APIKeyStore interface {
+	// GetAPIKey returns ErrNotFound when key does not exist.
+	GetAPIKey(ctx context.Context, id primitive.ObjectID) (APIKey, error)
+	GetAllAPIKeys(ctx context.Context) ([]APIKey, error)
+	SaveAPIKey(ctx context.Context, key APIKey) error
+	TouchAPIKey(ctx context.Context, id primitive.ObjectID, usedAt time.Time) error
+}
+
+// APIKeyManager issues, rotates, revokes and verifies API keys.
+type APIKeyManager struct {
+	store   APIKeyStore
+	limiter *rateLimiter
+}
+
+func NewAPIKeyManager(store APIKeyStore) *APIKeyManager {
+	return &APIKeyManager{store: store, limiter: newRateLimiter()}
+}
+
+// Create issues new API key with passed scopes and rate limit.
+func (m *APIKeyManager) Create(ctx context.Context, name string, scopes []string, rateLimit int) (IssuedAPIKey, error) {
+	key := APIKey{
+		Id:        primitive.NewObjectID(),
+		Name:      name,
+		Scopes:    scopes,
+		RateLimit: rateLimit,
+		CreatedAt: time.Now().UTC().Truncate(time.Millisecond),
+	}
+	if err := validateAPIKey(key); err != nil {
+		return IssuedAPIKey{}, err
+	}
+	return m.issue(ctx, key)
+}
+
+// Rotate replaces secret of the key, the previous secret stops working immediately.
+func (m *APIKeyManager) Rotate(ctx context.Context, id primitive.ObjectID) (IssuedAPIKey, error) {
+	key, err := m.store.GetAPIKey(ctx, id)
+	if err != nil {
+		return IssuedAPIKey{}, err
+	}
+	if key.RevokedAt != nil {
+		return IssuedAPIKey{}, fmt.Errorf("%w: api key %s is revoked", ErrConflict, id.Hex())
+	}
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	key.RotatedAt = &now
+	return m.issue(ctx, key)
+}
+
+// Revoke disables the key, revoked keys are kept for audit.
+func (m *APIKeyManager) Revoke(ctx context.Context, id primitive.ObjectID) (APIKey, error) {
+	key, err := m.store.GetAPIKey(ctx, id)
+	if err != nil {
+		return key, err
+	}
+	if key.RevokedAt == nil {
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		key.RevokedAt = &now
+		if err := m.store.SaveAPIKey(ctx, key); err != nil {
+			return key, err
+		}
+	}
+	return key, nil
+}
+
+func (m *APIKeyManager) List(ctx context.Context) ([]APIKey, error) {
+	return m.store.GetAllAPIKeys(ctx)
+}
+
+// Verify returns the key matching the secret and records its use. Unknown, malformed
+// and revoked keys are reported with ErrForbidden. Failed recording of the use is only logged.
+func (m *APIKeyManager) Verify(ctx context.Context, secret string) (APIKey, error) {
+	invalid := fmt.Errorf("%w: invalid api key", ErrForbidden)
+	idHex := strings.SplitN(secret, ".", 2)[0]
+	id, err := primitive.ObjectIDFromHex(idHex)
+	if err != nil {
+		return APIKey{}, invalid
+	}
+
+	key, err := m.store.GetAPIKey(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return key, invalid
+	}
+	if err != nil {
+		return key, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashAPIKey(secret)), []byte(key.Hash)) != 1 || key.RevokedAt != nil {
+		return key, invalid
+	}
+
+	now := time.Now().UTC()
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyLastUsedPrecision {
+		if err := m.store.TouchAPIKey(ctx, key.Id, now.Truncate(time.Millisecond)); err != nil {
+			LoggerFromContext(ctx).Warn("api key last use not recorded", "api_key_id", key.Id.Hex(), "error", err)
+		}
+	}
+	return key, nil
+}
+
+// allow applies rate limit of the key.
+func (m *APIKeyManager) allow(key APIKey) rateLimitResult {
+	if key.RateLimit <= 0 {
+		return rateLimitResult{allowed: true}
+	}
+	return m.limiter.allow(key.Id.Hex(), key.RateLimit)
+}
+
+// issue generates new secret of the key and stores its hash. The secret is prefixed
+// with key id, so the key can be found without scanning all hashes.
+func (m *APIKeyManager) issue(ctx context.Context, key APIKey) (IssuedAPIKey, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return IssuedAPIKey{}, err
+	}
+	secret := key.Id.Hex() + "." + base64.RawURLEncoding.EncodeToString(random)
+	key.Hash = hashAPIKey(secret)
+	if err := m.store.SaveAPIKey(ctx, key); err != nil {
+		return IssuedAPIKey{}, err
+	}
+	return IssuedAPIKey{APIKey: key, Key: secret}, nil
+}
+
+func hashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func validateAPIKey(key APIKey) error {
+	errs := &ValidationError{}
+	if strings.TrimSpace(key.Name) == "" {
+		errs.add("name", "is required")
+	}
+	if len(key.Scopes) == 0 {
+		errs.add("scopes", "at least one scope is required")
+	}
+	for _, scope := range key.Scopes {
+		if roleLevels[scope] == 0 {
+			errs.add("scopes", fmt.Sprintf("unknown scope %q", scope))
+		}
+	}
+	if key.RateLimit < 0 {
+		errs.add("rateLimit", "must not be negative")
+	}
+	return errs.orNil()
+}
+
+// apiKeyClaims returns claims of the caller authenticated by the key, scopes are its roles.
+func apiKeyClaims(key APIKey) Claims {
+	claims := Claims{Roles: key.Scopes}
+	claims.Subject = apiKeySubjectPrefix + key.Id.Hex()
+	return claims
+}
+
+// apiKeyRequest is the payload of API key creation.
+type apiKeyRequest struct {
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	RateLimit int      `json:"rateLimit"`
+}
+
+// getAPIKeysHandler lists API keys without secrets
+// endpoint: GET /api/v1/matching/apikeys
+func (s *Server) getAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := s.apiKeys.List(r.Context())
+	if err != nil {
+		respondStoreError(w, r, err)
+		return
+	}
+	Respond(w, r, http.StatusOK, keys)
+}
+
+// postAPIKeyHandler creates API key, the key is in the response only
+// endpoint: POST /api/v1/matching/apikeys
+// payload: {"name": "crm-sync", "scopes": ["reader"], "rateLimit": 600}
+func (s *Server) postAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		RespondError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	request := apiKeyRequest{}
+	if err := DecodeStrictJSON(data, &request); err != nil {
+		respondDecodeError(w, r, err)
+		return
+	}
+
+	issued, err := s.apiKeys.Create(r.Context(), request.Name, request.Scopes, request.RateLimit)
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		RespondValidationError(w, r, verr)
+		return
+	}
+	if err != nil {
+		respondStoreError(w, r, err)
+		return
+	}
+	Respond(w, r, http.StatusCreated, issued)
+}
+
+// postAPIKeyRotateHandler replaces secret of the API key
+// endpoint: POST /api/v1/matching/apikeys/{id}/rotate
+func (s *Server) postAPIKeyRotateHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := URLParamObjectID(r, "id")
+	if err != nil {
+		RespondError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	issued, err := s.apiKeys.Rotate(r.Context(), id)
+	if err != nil {
+		respondStoreError(w, r, err)
+		return
+	}
+	Respond(w, r, http.StatusOK, issued)
+}
+
+// deleteAPIKeyHandler revokes the API key
+// endpoint: DELETE /api/v1/matching/apikeys/{id}
+func (s *Server) deleteAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := URLParamObjectID(r, "id")
+	if err != nil {
+		RespondError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	key, err := s.apiKeys.Revoke(r.Context(), id)
+	if err != nil {
+		respondStoreError(w, r, err)
+		return
+	}
+	Respond(w, r, http.StatusOK, key)
+}
+
+// authenticateAPIKey verifies X-API-Key header and applies rate limit of the key.
+// It returns false when the response has been written.
+func (s *Server) authenticateAPIKey(w http.ResponseWriter, r *http.Request, secret string) (Claims, bool) {
+	key, err := s.apiKeys.Verify(r.Context(), secret)
+	if errors.Is(err, ErrForbidden) {
+		s.recordAuthFailure(r)
+		RespondError(w, r, http.StatusUnauthorized, err)
+		return Claims{}, false
+	}
+	if err != nil {
+		respondStoreError(w, r, err)
+		return Claims{}, false
+	}
+
+	if key.RateLimit > 0 && !respondRateLimit(w, r, s.apiKeys.allow(key)) {
+		return Claims{}, false
+	}
+	return apiKeyClaims(key), true
+}
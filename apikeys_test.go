@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	iss "github.com/matryer/is"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// apiKey returns API key header of the key for doRequest, no header when key is empty.
+func apiKey(key string) []string {
+	if key == "" {
+		return nil
+	}
+	return []string{headerAPIKey, key}
+}
+
+func TestServer_apiKeys(t *testing.T) {
+	is := iss.New(t)
+	store := NewMemoryStore()
+	s := NewServer("test", store, WithAPIKeys())
+	admin, err := s.apiKeys.Create(context.Background(), "admin", []string{RoleAdmin}, 0)
+	is.NoErr(err)
+
+	is.Equal(doRequest(t, s, http.MethodGet, "/", nil, apiKey("")...).Code, http.StatusUnauthorized)
+	is.Equal(doRequest(t, s, http.MethodGet, "/", nil, apiKey(admin.Key+"x")...).Code, http.StatusUnauthorized)
+
+	w := doRequest(t, s, http.MethodPost, "/apikeys", []byte(`{"name":"crm","scopes":["owner"],"rateLimit":-1}`), apiKey(admin.Key)...)
+	is.Equal(w.Code, http.StatusUnprocessableEntity)
+
+	w = doRequest(t, s, http.MethodPost, "/apikeys", []byte(`{"name":"crm","scopes":["reader"]}`), apiKey(admin.Key)...)
+	is.Equal(w.Code, http.StatusCreated)
+	var reader IssuedAPIKey
+	is.NoErr(json.NewDecoder(w.Body).Decode(&reader))
+	is.True(reader.Key != "")
+	is.Equal(doRequest(t, s, http.MethodGet, "/", nil, apiKey(reader.Key)...).Code, http.StatusOK)
+	is.Equal(doRequest(t, s, http.MethodGet, "/apikeys", nil, apiKey(reader.Key)...).Code, http.StatusForbidden)
+
+	stored, err := store.GetAPIKey(context.Background(), reader.Id)
+	is.NoErr(err)
+	is.True(stored.Hash != reader.Key)
+	is.True(stored.LastUsedAt != nil)
+
+	w = doRequest(t, s, http.MethodPost, "/apikeys/"+reader.Id.Hex()+"/rotate", nil, apiKey(admin.Key)...)
+	is.Equal(w.Code, http.StatusOK)
+	var rotated IssuedAPIKey
+	is.NoErr(json.NewDecoder(w.Body).Decode(&rotated))
+	is.Equal(doRequest(t, s, http.MethodGet, "/", nil, apiKey(reader.Key)...).Code, http.StatusUnauthorized)
+	is.Equal(doRequest(t, s, http.MethodGet, "/", nil, apiKey(rotated.Key)...).Code, http.StatusOK)
+
+	is.Equal(doRequest(t, s, http.MethodDelete, "/apikeys/"+reader.Id.Hex(), nil, apiKey(admin.Key)...).Code, http.StatusOK)
+	is.Equal(doRequest(t, s, http.MethodGet, "/", nil, apiKey(rotated.Key)...).Code, http.StatusUnauthorized)
+
+	w = doRequest(t, s, http.MethodGet, "/apikeys", nil, apiKey(admin.Key)...)
+	is.Equal(w.Code, http.StatusOK)
+	var keys []APIKey
+	is.NoErr(json.NewDecoder(w.Body).Decode(&keys))
+	is.Equal(len(keys), 2)
+	is.True(keys[1].RevokedAt != nil)
+}
+
+// failingTouchStore fails recording of the last use of API keys.
+type failingTouchStore struct {
+	*MemoryStore
+}
+
+func (s failingTouchStore) TouchAPIKey(ctx context.Context, id primitive.ObjectID, usedAt time.Time) error {
+	return errors.New("write concern timeout")
+}
+
+func TestServer_apiKeyTouchFailure(t *testing.T) {
+	is := iss.New(t)
+	s := NewServer("test", failingTouchStore{NewMemoryStore()}, WithAPIKeys())
+	reader, err := s.apiKeys.Create(context.Background(), "crm", []string{RoleReader}, 0)
+	is.NoErr(err)
+
+	// valid key is accepted even when its use can't be recorded
+	is.Equal(doRequest(t, s, http.MethodGet, "/", nil, apiKey(reader.Key)...).Code, http.StatusOK)
+}
+
+func TestServer_apiKeyRateLimit(t *testing.T) {
+	is := iss.New(t)
+	s := NewServer("test", NewMemoryStore(), WithAPIKeys())
+	issued, err := s.apiKeys.Create(context.Background(), "scorer", []string{RoleReader}, 2)
+	is.NoErr(err)
+
+	is.Equal(doRequest(t, s, http.MethodGet, "/", nil, apiKey(issued.Key)...).Code, http.StatusOK)
+	is.Equal(doRequest(t, s, http.MethodGet, "/", nil, apiKey(issued.Key)...).Code, http.StatusOK)
+	w := doRequest(t, s, http.MethodGet, "/", nil, apiKey(issued.Key)...)
+	is.Equal(w.Code, http.StatusTooManyRequests)
+	is.True(w.Header().Get("Retry-After") != "")
+}
+
+func TestRateLimiter_allow(t *testing.T) {
+	is := iss.New(t)
+	now := time.Now()
+	limiter := newRateLimiter()
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < 60; i++ {
+		is.True(limiter.allow("a", 60).allowed)
+	}
+	result := limiter.allow("a", 60)
+	is.True(!result.allowed)
+	is.Equal(result.remaining, 0)
+	is.Equal(result.retryAfter, time.Second)
+	is.Equal(result.reset, time.Minute)
+
+	is.True(limiter.allow("b", 60).allowed) // buckets are per key
+
+	now = now.Add(time.Second)
+	is.True(limiter.allow("a", 60).allowed)
+}
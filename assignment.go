@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxAssignmentMembers limits the number of summaries on each side of the assignment.
+const maxAssignmentMembers = 1000
+
+// AssignmentRequest selects two disjoint sets of summaries to pair.
+// Preferences are read from stored matchings, a pair is acceptable only when it is
+// matched in both directions with matchRate of at least MinRate.
+type AssignmentRequest struct {
+	Proposers []primitive.ObjectID `json:"proposers"`
+	Acceptors []primitive.ObjectID `json:"acceptors"`
+	// Capacity is the number of proposers assigned to one acceptor, 0 means 1.
+	Capacity int `json:"capacity"`
+	// Capacities overrides Capacity of acceptors keyed by summary id.
+	Capacities map[string]int `json:"capacities"`
+	MinRate    int            `json:"minRate"`
+}
+
+// AssignedPair is a proposer assigned to an acceptor.
+type AssignedPair struct {
+	ProposerId primitive.ObjectID `json:"proposerId"`
+	AcceptorId primitive.ObjectID `json:"acceptorId"`
+	// MatchRate is the rate of the proposer matched with the acceptor.
+	MatchRate int `json:"matchRate"`
+	// ReverseMatchRate is the rate of the acceptor matched with the proposer.
+	ReverseMatchRate int `json:"reverseMatchRate"`
+}
+
+// Assignment lists assigned pairs ordered as proposers of the request and members left without a pair.
+type Assignment struct {
+	Pairs []AssignedPair `json:"pairs"`
+	// Total is the sum of matchRate of assigned pairs in both directions.
+	Total              int                  `json:"total"`
+	UnmatchedProposers []primitive.ObjectID `json:"unmatchedProposers"`
+	UnmatchedAcceptors []primitive.ObjectID `json:"unmatchedAcceptors"`
+}
+
+// validate checks the request and returns capacity of every acceptor.
+func (req AssignmentRequest) validate() (map[primitive.ObjectID]int, error) {
+	verr := &ValidationError{}
+	seen := make(map[primitive.ObjectID]bool)
+	checkMembers := func(field string, ids []primitive.ObjectID) {
+		switch {
+		case len(ids) == 0:
+			verr.add(field, "is required")
+		case len(ids) > maxAssignmentMembers:
+			verr.add(field, "too many summaries")
+		}
+		for _, id := range ids {
+			if seen[id] && !verr.has(field) {
+				verr.add(field, "duplicated summary "+id.Hex())
+			}
+			seen[id] = true
+		}
+	}
+	checkMembers("proposers", req.Proposers)
+	checkMembers("acceptors", req.Acceptors)
+	if req.Capacity < 0 || req.Capacity > maxAssignmentMembers {
+		verr.add("capacity", fmt.Sprintf("must be between 0 and %d", maxAssignmentMembers))
+	}
+	if req.MinRate < 0 || req.MinRate > 100 {
+		verr.add("minRate", "must be between 0 and 100")
+	}
+
+	capacity := req.Capacity
+	if capacity == 0 {
+		capacity = 1
+	}
+	capacities := make(map[primitive.ObjectID]int, len(req.Acceptors))
+	for _, id := range req.Acceptors {
+		capacities[id] = capacity
+	}
+	for hex, c := range req.Capacities {
+		id, err := primitive.ObjectIDFromHex(hex)
+		if _, ok := capacities[id]; err != nil || !ok {
+			verr.add("capacities", "unknown acceptor "+hex)
+			continue
+		}
+		if c < 1 || c > maxAssignmentMembers {
+			verr.add("capacities", fmt.Sprintf("capacity of %s must be between 1 and %d", hex, maxAssignmentMembers))
+			continue
+		}
+		capacities[id] = c
+	}
+	return capacities, verr.orNil()
+}
+
+// pairKey identifies directed matching of the summary pair.
+type pairKey struct {
+	summaryId, matchedSummaryId primitive.ObjectID
+}
+
+// pairRates indexes matchRate of matchings by summary pair.
+type pairRates map[pairKey]int
+
+func newPairRates(matchings []*Matching) pairRates {
+	rates := make(pairRates, len(matchings))
+	for _, m := range matchings {
+		key := pairKey{m.SummaryId, m.MatchedSummaryId}
+		if _, ok := rates[key]; !ok {
+			rates[key] = m.MatchRate
+		}
+	}
+	return rates
+}
+
+// mutual returns rates of both directions, ok is false when the pair is not matched
+// in both directions with at least minRate.
+func (p pairRates) mutual(a, b primitive.ObjectID, minRate int) (rate, reverse int, ok bool) {
+	rate, forwardOk := p[pairKey{a, b}]
+	reverse, reverseOk := p[pairKey{b, a}]
+	ok = forwardOk && reverseOk && rate >= minRate && reverse >= minRate
+	return rate, reverse, ok
+}
+
+// prefers reports whether x is ranked before y by rate, ties are broken by the lower id.
+func prefers(rateX, rateY int, x, y primitive.ObjectID) bool {
+	if rateX != rateY {
+		return rateX > rateY
+	}
+	return bytes.Compare(x[:], y[:]) < 0
+}
+
+// loadAssignmentRates reads matchings between proposers and acceptors in both directions.
+func loadAssignmentRates(ctx context.Context, store MatchingStore, req AssignmentRequest) (pairRates, error) {
+	forward, err := store.GetMatchingsBetween(ctx, req.Proposers, req.Acceptors)
+	if err != nil {
+		return nil, err
+	}
+	reverse, err := store.GetMatchingsBetween(ctx, req.Acceptors, req.Proposers)
+	if err != nil {
+		return nil, err
+	}
+	return newPairRates(append(forward, reverse...)), nil
+}
+
+// StableAssignment pairs proposers with acceptors by proposer-proposing Gale–Shapley
+// deferred acceptance, so no proposer and acceptor would both rather be paired together.
+// Each acceptor takes up to its capacity of proposers. It returns *ValidationError for invalid request.
+func StableAssignment(ctx context.Context, store MatchingStore, req AssignmentRequest) (Assignment, error) {
+	capacities, err := req.validate()
+	if err != nil {
+		return Assignment{}, err
+	}
+	rates, err := loadAssignmentRates(ctx, store, req)
+	if err != nil {
+		return Assignment{}, err
+	}
+	return stableAssignment(req.Proposers, req.Acceptors, capacities, rates, req.MinRate), nil
+}
+
+func stableAssignment(proposers, acceptors []primitive.ObjectID, capacities map[primitive.ObjectID]int, rates pairRates, minRate int) Assignment {
+	// acceptable acceptors of each proposer from the most preferred
+	preferences := make(map[primitive.ObjectID][]primitive.ObjectID, len(proposers))
+	for _, p := range proposers {
+		list := make([]primitive.ObjectID, 0)
+		for _, a := range acceptors {
+			if _, _, ok := rates.mutual(p, a, minRate); ok {
+				list = append(list, a)
+			}
+		}
+		sort.SliceStable(list, func(i, j int) bool {
+			return prefers(rates[pairKey{p, list[i]}], rates[pairKey{p, list[j]}], list[i], list[j])
+		})
+		preferences[p] = list
+	}
+
+	next := make(map[primitive.ObjectID]int, len(proposers))
+	held := make(map[primitive.ObjectID][]primitive.ObjectID, len(acceptors))
+	free := append([]primitive.ObjectID(nil), proposers...)
+	for len(free) > 0 {
+		p := free[0]
+		free = free[1:]
+		if next[p] >= len(preferences[p]) {
+			continue
+		}
+		a := preferences[p][next[p]]
+		next[p]++
+
+		held[a] = append(held[a], p)
+		if len(held[a]) <= capacities[a] {
+			continue
+		}
+		worst := 0
+		for i, q := range held[a] {
+			w := held[a][worst]
+			if prefers(rates[pairKey{a, w}], rates[pairKey{a, q}], w, q) {
+				worst = i
+			}
+		}
+		free = append(free, held[a][worst])
+		held[a] = append(held[a][:worst], held[a][worst+1:]...)
+	}
+
+	assignedTo := make(map[primitive.ObjectID]primitive.ObjectID, len(proposers))
+	for a, list := range held {
+		for _, p := range list {
+			assignedTo[p] = a
+		}
+	}
+	return newAssignment(proposers, acceptors, assignedTo, rates)
+}
+
+// newAssignment lists pairs of assignedTo ordered as proposers and members left without a pair.
+func newAssignment(proposers, acceptors []primitive.ObjectID, assignedTo map[primitive.ObjectID]primitive.ObjectID, rates pairRates) Assignment {
+	result := Assignment{
+		Pairs:              make([]AssignedPair, 0, len(assignedTo)),
+		UnmatchedProposers: make([]primitive.ObjectID, 0),
+		UnmatchedAcceptors: make([]primitive.ObjectID, 0),
+	}
+	taken := make(map[primitive.ObjectID]bool, len(acceptors))
+	for _, p := range proposers {
+		a, ok := assignedTo[p]
+		if !ok {
+			result.UnmatchedProposers = append(result.UnmatchedProposers, p)
+			continue
+		}
+		taken[a] = true
+		pair := AssignedPair{ProposerId: p, AcceptorId: a, MatchRate: rates[pairKey{p, a}], ReverseMatchRate: rates[pairKey{a, p}]}
+		result.Pairs = append(result.Pairs, pair)
+		result.Total += pair.MatchRate + pair.ReverseMatchRate
+	}
+	for _, a := range acceptors {
+		if !taken[a] {
+			result.UnmatchedAcceptors = append(result.UnmatchedAcceptors, a)
+		}
+	}
+	return result
+}
+
+// assignmentHandler computes assignment of the posted summary sets by assign from matchings
+// of the read version, nothing is stored.
+// endpoint: POST /api/v1/matching/assignments/{stable|optimal}
+func (s *Server) assignmentHandler(assign func(context.Context, MatchingStore, AssignmentRequest) (Assignment, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// assignment reads matchings of many summaries, caller acting for a profile can't see them
+		if claims, ok := ClaimsFromContext(r.Context()); ok && claims.ProfileId != "" {
+			RespondError(w, r, http.StatusForbidden, "assignment is not available to profile scoped callers")
+			return
+		}
+
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			RespondError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		request := AssignmentRequest{}
+		if err := DecodeStrictJSON(data, &request); err != nil {
+			respondDecodeError(w, r, err)
+			return
+		}
+
+		// assignment has no summary of its own, only the version parameter or the default routing applies
+		store, err := s.readStore(w, r, primitive.NilObjectID)
+		if err != nil {
+			respondStoreError(w, r, err)
+			return
+		}
+		assignment, err := assign(r.Context(), store, request)
+		var verr *ValidationError
+		if errors.As(err, &verr) {
+			RespondValidationError(w, r, verr)
+			return
+		}
+		if err != nil {
+			respondStoreError(w, r, err)
+			return
+		}
+		Respond(w, r, http.StatusOK, assignment)
+	}
+}
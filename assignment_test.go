@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	iss "github.com/matryer/is"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// storeRates creates matching of every pair listed in rates as [from, to, rate].
+func storeRates(t *testing.T, store *MemoryStore, ids map[string]primitive.ObjectID, rates [][3]interface{}) {
+	is := iss.New(t)
+	for _, r := range rates {
+		_, err := store.CreateMatching(context.Background(), Matching{
+			SummaryId: ids[r[0].(string)], MatchedSummaryId: ids[r[1].(string)], MatchRate: r[2].(int)})
+		is.NoErr(err)
+	}
+}
+
+func postAssignment(t *testing.T, s *Server, method string, req AssignmentRequest) (int, Assignment) {
+	is := iss.New(t)
+	body, err := json.Marshal(req)
+	is.NoErr(err)
+	w := doRequest(t, s, http.MethodPost, "/assignments/"+method, body)
+	var assignment Assignment
+	if w.Code == http.StatusOK {
+		is.NoErr(json.NewDecoder(w.Body).Decode(&assignment))
+	}
+	return w.Code, assignment
+}
+
+func TestServer_postStableAssignmentHandler(t *testing.T) {
+	s, store := newTestServer(t)
+	ids := make(map[string]primitive.ObjectID)
+	for _, name := range []string{"p1", "p2", "p3", "p4", "a1", "a2", "a3", "b1", "b2", "b3"} {
+		ids[name] = primitive.NewObjectID()
+	}
+	// p1: a1 > a2 > a3, p2: a1 > a3 > a2, p3: a2 > a1 > a3
+	// a1: p3 > p2 > p1, a2: p1 > p3 > p2, a3: p2 > p1 > p3
+	storeRates(t, store, ids, [][3]interface{}{
+		{"p1", "a1", 90}, {"p1", "a2", 60}, {"p1", "a3", 30},
+		{"p2", "a1", 90}, {"p2", "a3", 60}, {"p2", "a2", 30},
+		{"p3", "a2", 90}, {"p3", "a1", 60}, {"p3", "a3", 30},
+		{"a1", "p3", 90}, {"a1", "p2", 60}, {"a1", "p1", 30},
+		{"a2", "p1", 90}, {"a2", "p3", 60}, {"a2", "p2", 30},
+		{"a3", "p2", 90}, {"a3", "p1", 60}, {"a3", "p3", 30},
+		// b1 takes two proposers, b2 is acceptable to p2 only, b3 has no matchings
+		{"p1", "b1", 80}, {"p2", "b1", 80}, {"p3", "b1", 80}, {"p2", "b2", 40},
+		{"b1", "p3", 70}, {"b1", "p1", 50}, {"b1", "p2", 20}, {"b2", "p2", 40},
+	})
+	members := func(names ...string) []primitive.ObjectID {
+		result := make([]primitive.ObjectID, len(names))
+		for i, name := range names {
+			result[i] = ids[name]
+		}
+		return result
+	}
+	pair := func(p, a string) [2]primitive.ObjectID { return [2]primitive.ObjectID{ids[p], ids[a]} }
+
+	tests := []struct {
+		name               string
+		req                AssignmentRequest
+		wantPairs          [][2]primitive.ObjectID
+		wantUnmatchedProp  []primitive.ObjectID
+		wantUnmatchedAccep []primitive.ObjectID
+	}{
+		{
+			name:               "proposer optimal",
+			req:                AssignmentRequest{Proposers: members("p1", "p2", "p3"), Acceptors: members("a1", "a2", "a3")},
+			wantPairs:          [][2]primitive.ObjectID{pair("p1", "a2"), pair("p2", "a3"), pair("p3", "a1")},
+			wantUnmatchedProp:  []primitive.ObjectID{},
+			wantUnmatchedAccep: []primitive.ObjectID{},
+		},
+		{
+			name: "capacities",
+			req: AssignmentRequest{Proposers: members("p1", "p2", "p3", "p4"), Acceptors: members("b1", "b2", "b3"),
+				Capacities: map[string]int{ids["b1"].Hex(): 2}},
+			wantPairs:          [][2]primitive.ObjectID{pair("p1", "b1"), pair("p2", "b2"), pair("p3", "b1")},
+			wantUnmatchedProp:  members("p4"),
+			wantUnmatchedAccep: members("b3"),
+		},
+		{
+			name:               "min rate",
+			req:                AssignmentRequest{Proposers: members("p1", "p2", "p3"), Acceptors: members("b1", "b2"), MinRate: 50},
+			wantPairs:          [][2]primitive.ObjectID{pair("p3", "b1")},
+			wantUnmatchedProp:  members("p1", "p2"),
+			wantUnmatchedAccep: members("b2"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := iss.New(t)
+			code, assignment := postAssignment(t, s, "stable", tt.req)
+			is.Equal(code, http.StatusOK)
+
+			gotPairs := make([][2]primitive.ObjectID, 0)
+			for _, p := range assignment.Pairs {
+				gotPairs = append(gotPairs, [2]primitive.ObjectID{p.ProposerId, p.AcceptorId})
+			}
+			is.Equal(gotPairs, tt.wantPairs)
+			is.Equal(assignment.UnmatchedProposers, tt.wantUnmatchedProp)
+			is.Equal(assignment.UnmatchedAcceptors, tt.wantUnmatchedAccep)
+		})
+	}
+
+	invalid := []AssignmentRequest{
+		{Acceptors: members("a1")},
+		{Proposers: members("p1", "a1"), Acceptors: members("a1")},
+		{Proposers: members("p1"), Acceptors: members("a1"), Capacities: map[string]int{ids["b1"].Hex(): 2}},
+		{Proposers: members("p1"), Acceptors: members("a1"), MinRate: 101},
+	}
+	for _, req := range invalid {
+		code, _ := postAssignment(t, s, "stable", req)
+		iss.New(t).Equal(code, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestServer_assignmentVersions(t *testing.T) {
+	is := iss.New(t)
+	s, store := newTestServer(t)
+	ctx := context.Background()
+	proposer, acceptor := primitive.NewObjectID(), primitive.NewObjectID()
+	candidate, err := store.Namespace("v2")
+	is.NoErr(err)
+	for _, m := range []Matching{
+		{SummaryId: proposer, MatchedSummaryId: acceptor, MatchRate: 80},
+		{SummaryId: acceptor, MatchedSummaryId: proposer, MatchRate: 70},
+	} {
+		_, err := candidate.CreateMatching(ctx, m)
+		is.NoErr(err)
+	}
+	body, err := json.Marshal(AssignmentRequest{Proposers: []primitive.ObjectID{proposer}, Acceptors: []primitive.ObjectID{acceptor}})
+	is.NoErr(err)
+
+	// default namespace has no matchings
+	w := doRequest(t, s, http.MethodPost, "/assignments/stable", body)
+	is.Equal(w.Code, http.StatusOK)
+	var assignment Assignment
+	is.NoErr(json.NewDecoder(w.Body).Decode(&assignment))
+	is.Equal(len(assignment.Pairs), 0)
+
+	w = doRequest(t, s, http.MethodPost, "/assignments/stable?version=v2", body)
+	is.Equal(w.Code, http.StatusOK)
+	is.Equal(w.Header().Get(headerScorerVersion), "v2")
+	is.NoErr(json.NewDecoder(w.Body).Decode(&assignment))
+	is.Equal(len(assignment.Pairs), 1)
+
+	w = doRequest(t, s, http.MethodPost, "/assignments/optimal?version=v3", body)
+	is.Equal(w.Code, http.StatusNotFound)
+
+	// routed default version serves assignments too
+	routed := NewServer("test", store, WithVersionRouting(VersionRouting{Default: "v2"}))
+	w = doRequest(t, routed, http.MethodPost, "/assignments/optimal", body)
+	is.Equal(w.Code, http.StatusOK)
+	is.Equal(w.Header().Get(headerScorerVersion), "v2")
+	is.NoErr(json.NewDecoder(w.Body).Decode(&assignment))
+	is.Equal(len(assignment.Pairs), 1)
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Roles granted by the roles claim of the token. Each role includes the roles before it,
+// writer can read and admin can do everything.
+const (
+	RoleReader = "reader"
+	RoleWriter = "writer"
+	RoleAdmin  = "admin"
+)
+
+var roleLevels = map[string]int{RoleReader: 1, RoleWriter: 2, RoleAdmin: 3}
+
+// ErrForbidden is returned when authenticated caller is not allowed to access the resource.
+var ErrForbidden = errors.New("forbidden")
+
+// AuthConfig selects token verification keys. At least one key source is required.
+type AuthConfig struct {
+	// HMACKeyFile contains the shared secret of HS256 tokens.
+	HMACKeyFile string `yaml:"hmacKeyFile"`
+	// PublicKeyFile contains PEM encoded RSA public key of RS256 tokens.
+	PublicKeyFile string `yaml:"publicKeyFile"`
+	// JWKSFile contains JSON Web Key Set with RSA and oct keys selected by kid header.
+	JWKSFile string `yaml:"jwksFile"`
+	Issuer   string `yaml:"issuer"`
+	Audience string `yaml:"audience"`
+}
+
+// Enabled reports whether any verification key is configured.
+func (c AuthConfig) Enabled() bool {
+	return c.HMACKeyFile != "" || c.PublicKeyFile != "" || c.JWKSFile != ""
+}
+
+// Claims of the access token. ProfileId restricts reads to matchings of summaries of the profile.
+type Claims struct {
+	jwt.RegisteredClaims
+	Roles     []string `json:"roles"`
+	ProfileId string   `json:"profileId,omitempty"`
+}
+
+// HasRole reports whether claims grant the role directly or through a higher role.
+func (c Claims) HasRole(role string) bool {
+	for _, granted := range c.Roles {
+		if roleLevels[granted] >= roleLevels[role] && roleLevels[role] > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator verifies HS256 and RS256 tokens with keys loaded from local files.
+type Authenticator struct {
+	hmacKeys map[string][]byte
+	rsaKeys  map[string]*rsa.PublicKey
+	issuer   string
+	audience string
+	parser   *jwt.Parser
+}
+
+// NewAuthenticator loads verification keys. Keys of HMACKeyFile and PublicKeyFile have empty kid.
+func NewAuthenticator(cfg AuthConfig) (*Authenticator, error) {
+	a := Authenticator{
+		hmacKeys: make(map[string][]byte),
+		rsaKeys:  make(map[string]*rsa.PublicKey),
+		issuer:   cfg.Issuer,
+		audience: cfg.Audience,
+		parser:   jwt.NewParser(jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg(), jwt.SigningMethodRS256.Alg()})),
+	}
+
+	if cfg.HMACKeyFile != "" {
+		data, err := ioutil.ReadFile(cfg.HMACKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("auth: %w", err)
+		}
+		a.hmacKeys[""] = []byte(strings.TrimSpace(string(data)))
+	}
+	if cfg.PublicKeyFile != "" {
+		data, err := ioutil.ReadFile(cfg.PublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("auth: %w", err)
+		}
+		if a.rsaKeys[""], err = jwt.ParseRSAPublicKeyFromPEM(data); err != nil {
+			return nil, fmt.Errorf("auth: %s: %w", cfg.PublicKeyFile, err)
+		}
+	}
+	if cfg.JWKSFile != "" {
+		if err := a.loadJWKS(cfg.JWKSFile); err != nil {
+			return nil, fmt.Errorf("auth: %s: %w", cfg.JWKSFile, err)
+		}
+	}
+
+	if len(a.hmacKeys) == 0 && len(a.rsaKeys) == 0 {
+		return nil, errors.New("auth: no verification keys configured")
+	}
+	return &a, nil
+}
+
+// jsonWebKey is the subset of RFC 7517 key fields used for verification.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	K   string `json:"k"`
+}
+
+func (a *Authenticator) loadJWKS(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	set := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return err
+	}
+
+	for _, key := range set.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		switch key.Kty {
+		case "RSA":
+			n, err := base64.RawURLEncoding.DecodeString(key.N)
+			if err != nil {
+				return fmt.Errorf("key %q: invalid modulus: %w", key.Kid, err)
+			}
+			e, err := base64.RawURLEncoding.DecodeString(key.E)
+			if err != nil {
+				return fmt.Errorf("key %q: invalid exponent: %w", key.Kid, err)
+			}
+			a.rsaKeys[key.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "oct":
+			k, err := base64.RawURLEncoding.DecodeString(key.K)
+			if err != nil {
+				return fmt.Errorf("key %q: invalid secret: %w", key.Kid, err)
+			}
+			a.hmacKeys[key.Kid] = k
+		}
+	}
+	return nil
+}
+
+// ParseClaims verifies the token signature, expiration, issuer and audience and returns its claims.
+// Token without expiration is rejected, it would never expire.
+func (a *Authenticator) ParseClaims(tokenStr string) (Claims, error) {
+	claims := Claims{}
+	if _, err := a.parser.ParseWithClaims(tokenStr, &claims, a.key); err != nil {
+		return claims, err
+	}
+	if claims.ExpiresAt == nil {
+		return claims, errors.New("missing expiration")
+	}
+	if a.issuer != "" && !claims.VerifyIssuer(a.issuer, true) {
+		return claims, errors.New("invalid issuer")
+	}
+	if a.audience != "" && !claims.VerifyAudience(a.audience, true) {
+		return claims, errors.New("invalid audience")
+	}
+	return claims, nil
+}
+
+// key selects verification key by the algorithm and kid header of the token.
+// Token without kid is verified with the only key of its algorithm.
+func (a *Authenticator) key(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	switch token.Method.Alg() {
+	case jwt.SigningMethodHS256.Alg():
+		if key, ok := a.hmacKeys[kid]; ok {
+			return key, nil
+		}
+		if kid == "" && len(a.hmacKeys) == 1 {
+			for _, key := range a.hmacKeys {
+				return key, nil
+			}
+		}
+	case jwt.SigningMethodRS256.Alg():
+		if key, ok := a.rsaKeys[kid]; ok {
+			return key, nil
+		}
+		if kid == "" && len(a.rsaKeys) == 1 {
+			for _, key := range a.rsaKeys {
+				return key, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no %s key with kid %q", token.Method.Alg(), kid)
+}
+
+type authContextKey int
+
+const claimsContextKey authContextKey = 1
+
+// ClaimsFromContext returns claims of the authenticated request.
+func ClaimsFromContext(ctx context.Context) (Claims, bool) {
+	claims, ok := ctx.Value(claimsContextKey).(Claims)
+	return claims, ok
+}
+
+// authenticate verifies API key or bearer token of the request and stores claims of the caller
+// in the request context. All requests pass when neither tokens nor API keys are configured.
+// Failed authentications are limited per IP address when rate limits are enabled.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.authEnabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !s.checkAuthFailures(w, r) {
+			return
+		}
+
+		var claims Claims
+		header := r.Header.Get("Authorization")
+		switch secret := r.Header.Get(headerAPIKey); {
+		case secret != "" && s.apiKeys != nil:
+			var ok bool
+			if claims, ok = s.authenticateAPIKey(w, r, secret); !ok {
+				return
+			}
+		case strings.HasPrefix(header, "Bearer ") && s.authenticator != nil:
+			var err error
+			claims, err = s.authenticator.ParseClaims(strings.TrimPrefix(header, "Bearer "))
+			if err != nil {
+				s.recordAuthFailure(r)
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				RespondError(w, r, http.StatusUnauthorized, "invalid token: ", err)
+				return
+			}
+		default:
+			s.recordAuthFailure(r)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			RespondError(w, r, http.StatusUnauthorized, "missing bearer token or api key")
+			return
+		}
+
+		setRequestUser(r.Context(), claims.Subject)
+		ctx := context.WithValue(r.Context(), claimsContextKey, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// authEnabled reports whether callers have to authenticate.
+func (s *Server) authEnabled() bool {
+	return s.authenticator != nil || s.apiKeys != nil
+}
+
+// requireRole rejects requests of callers without the role.
+func (s *Server) requireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !s.authEnabled() {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			claims, ok := ClaimsFromContext(r.Context())
+			if !ok || !claims.HasRole(role) {
+				RespondError(w, r, http.StatusForbidden, "role ", role, " required")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// authorizeSummary checks that caller acting for a profile reads matchings of its own summary.
+// Missing summary is reported as ErrForbidden so summaries of other profiles can't be probed.
+func (s *Server) authorizeSummary(ctx context.Context, summaryID primitive.ObjectID) error {
+	claims, ok := ClaimsFromContext(ctx)
+	if !ok || claims.ProfileId == "" {
+		return nil
+	}
+
+	summary, err := s.store.GetSummary(ctx, summaryID)
+	if errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("%w: summary %s", ErrForbidden, summaryID.Hex())
+	}
+	if err != nil {
+		return err
+	}
+	if profileIdOf(summary) != claims.ProfileId {
+		return fmt.Errorf("%w: summary %s belongs to other profile", ErrForbidden, summaryID.Hex())
+	}
+	return nil
+}
+
+// profileIdOf returns hex profileId of the summary, stored either as ObjectID or string.
+func profileIdOf(summary SummaryDocument) string {
+	switch id := summary["profileId"].(type) {
+	case primitive.ObjectID:
+		return id.Hex()
+	case string:
+		return id
+	}
+	return ""
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	iss "github.com/matryer/is"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const testHMACSecret = "test-secret"
+
+func newTestAuthServer(t *testing.T) (*Server, *MemoryStore) {
+	path := filepath.Join(t.TempDir(), "hmac.key")
+	if err := ioutil.WriteFile(path, []byte(testHMACSecret+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	authenticator, err := NewAuthenticator(AuthConfig{HMACKeyFile: path, Issuer: "winawin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewMemoryStore()
+	return NewServer("test", store, WithAuthenticator(authenticator)), store
+}
+
+func signHS256(t *testing.T, claims Claims) string {
+	if claims.Issuer == "" {
+		claims.Issuer = "winawin"
+	}
+	if claims.ExpiresAt == nil {
+		claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Hour))
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testHMACSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// bearer returns Authorization header of the token for doRequest, no header when token is empty.
+func bearer(token string) []string {
+	if token == "" {
+		return nil
+	}
+	return []string{"Authorization", "Bearer " + token}
+}
+
+func TestServer_authenticate(t *testing.T) {
+	s, _ := newTestAuthServer(t)
+	expired := Claims{Roles: []string{RoleAdmin}}
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+	unexpiring := Claims{Roles: []string{RoleAdmin}, RegisteredClaims: jwt.RegisteredClaims{Issuer: "winawin"}}
+	signedUnexpiring, err := jwt.NewWithClaims(jwt.SigningMethodHS256, unexpiring).SignedString([]byte(testHMACSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		token  string
+		status int
+	}{
+		{name: "no token", method: http.MethodGet, target: "/", status: http.StatusUnauthorized},
+		{name: "garbage token", method: http.MethodGet, target: "/", token: "abc", status: http.StatusUnauthorized},
+		{name: "expired", method: http.MethodGet, target: "/", token: signHS256(t, expired), status: http.StatusUnauthorized},
+		{name: "no expiration", method: http.MethodGet, target: "/", token: signedUnexpiring, status: http.StatusUnauthorized},
+		{name: "wrong issuer", method: http.MethodGet, target: "/", token: signHS256(t, Claims{RegisteredClaims: jwt.RegisteredClaims{Issuer: "other"}, Roles: []string{RoleReader}}), status: http.StatusUnauthorized},
+		{name: "no role", method: http.MethodGet, target: "/", token: signHS256(t, Claims{}), status: http.StatusForbidden},
+		{name: "reader reads", method: http.MethodGet, target: "/", token: signHS256(t, Claims{Roles: []string{RoleReader}}), status: http.StatusOK},
+		{name: "reader can't write", method: http.MethodDelete, target: "/" + primitive.NewObjectID().Hex(), token: signHS256(t, Claims{Roles: []string{RoleReader}}), status: http.StatusForbidden},
+		{name: "writer writes", method: http.MethodDelete, target: "/" + primitive.NewObjectID().Hex(), token: signHS256(t, Claims{Roles: []string{RoleWriter}}), status: http.StatusNotFound},
+		{name: "writer can't verify", method: http.MethodGet, target: "/verify", token: signHS256(t, Claims{Roles: []string{RoleWriter}}), status: http.StatusForbidden},
+		{name: "admin verifies", method: http.MethodGet, target: "/verify", token: signHS256(t, Claims{Roles: []string{RoleAdmin}}), status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := iss.New(t)
+			w := doRequest(t, s, tt.method, tt.target, nil, bearer(tt.token)...)
+			is.Equal(w.Code, tt.status)
+		})
+	}
+}
+
+func TestServer_authorizeSummary(t *testing.T) {
+	is := iss.New(t)
+	s, store := newTestAuthServer(t)
+	ctx := context.Background()
+	profileId := primitive.NewObjectID()
+	own, other := makeObjectId(t, summaryId1), makeObjectId(t, summaryId2)
+	store.PutSummary(own, SummaryDocument{"profileId": profileId})
+	store.PutSummary(other, SummaryDocument{"profileId": primitive.NewObjectID()})
+	otherMatchingId, err := store.CreateMatching(ctx, Matching{SummaryId: other, MatchedSummaryId: own, MatchRate: 10})
+	is.NoErr(err)
+
+	token := signHS256(t, Claims{Roles: []string{RoleReader}, ProfileId: profileId.Hex()})
+	is.Equal(doRequest(t, s, http.MethodGet, "/summary/"+own.Hex(), nil, bearer(token)...).Code, http.StatusOK)
+	is.Equal(doRequest(t, s, http.MethodGet, "/summary/"+other.Hex(), nil, bearer(token)...).Code, http.StatusForbidden)
+	is.Equal(doRequest(t, s, http.MethodGet, "/summary/"+primitive.NewObjectID().Hex(), nil, bearer(token)...).Code, http.StatusForbidden)
+	is.Equal(doRequest(t, s, http.MethodGet, "/"+otherMatchingId.Hex(), nil, bearer(token)...).Code, http.StatusForbidden)
+	is.Equal(doRequest(t, s, http.MethodGet, "/", nil, bearer(token)...).Code, http.StatusForbidden)
+	is.Equal(doRequest(t, s, http.MethodGet, "/?summaryId="+own.Hex(), nil, bearer(token)...).Code, http.StatusOK)
+
+	// unscoped reader reads everything
+	token = signHS256(t, Claims{Roles: []string{RoleReader}})
+	is.Equal(doRequest(t, s, http.MethodGet, "/"+otherMatchingId.Hex(), nil, bearer(token)...).Code, http.StatusOK)
+}
+
+func TestAuthenticator_JWKS(t *testing.T) {
+	is := iss.New(t)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	is.NoErr(err)
+	jwks, err := json.Marshal(map[string]interface{}{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": "key-1",
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}})
+	is.NoErr(err)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	is.NoErr(ioutil.WriteFile(path, jwks, 0600))
+
+	authenticator, err := NewAuthenticator(AuthConfig{JWKSFile: path})
+	is.NoErr(err)
+
+	expiresAt := jwt.NewNumericDate(time.Now().Add(time.Hour))
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, Claims{Roles: []string{RoleWriter}, RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: expiresAt}})
+	token.Header["kid"] = "key-1"
+	signed, err := token.SignedString(key)
+	is.NoErr(err)
+	claims, err := authenticator.ParseClaims(signed)
+	is.NoErr(err)
+	is.True(claims.HasRole(RoleReader))
+	is.True(!claims.HasRole(RoleAdmin))
+
+	token.Header["kid"] = "key-2"
+	signed, err = token.SignedString(key)
+	is.NoErr(err)
+	_, err = authenticator.ParseClaims(signed)
+	is.True(err != nil)
+
+	// HS256 token must not be verified with RSA key material
+	signed, err = jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: expiresAt}}).SignedString([]byte("key-1"))
+	is.NoErr(err)
+	_, err = authenticator.ParseClaims(signed)
+	is.True(err != nil)
+}
+
+func TestAuthenticator_audience(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hmac.key")
+	iss.New(t).NoErr(ioutil.WriteFile(path, []byte(testHMACSecret), 0600))
+	authenticator, err := NewAuthenticator(AuthConfig{HMACKeyFile: path, Audience: "matching"})
+	iss.New(t).NoErr(err)
+
+	tests := []struct {
+		name     string
+		audience jwt.ClaimStrings
+		wantErr  bool
+	}{
+		{name: "single", audience: jwt.ClaimStrings{"matching"}},
+		{name: "array", audience: jwt.ClaimStrings{"profiles", "matching"}},
+		{name: "other", audience: jwt.ClaimStrings{"profiles"}, wantErr: true},
+		{name: "missing", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := iss.New(t)
+			claims := Claims{RegisteredClaims: jwt.RegisteredClaims{Audience: tt.audience, ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))}}
+			signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testHMACSecret))
+			is.NoErr(err)
+			_, err = authenticator.ParseClaims(signed)
+			is.Equal(err != nil, tt.wantErr)
+		})
+	}
+}
+
+func TestServer_authRateLimit(t *testing.T) {
+	is := iss.New(t)
+	authServer, _ := newTestAuthServer(t)
+	s := NewServer("test", NewMemoryStore(), WithAuthenticator(authServer.authenticator), WithRateLimits(RateLimits{Read: 2, Admin: 1}))
+	request := func(method, target, token, remoteAddr string) int {
+		req := httptest.NewRequest(method, target, nil)
+		req.RemoteAddr = remoteAddr
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		s.Router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// failed authentications use up the bucket of the IP address, limited by the highest group limit
+	is.Equal(request(http.MethodGet, "/", "abc", "10.0.0.1:1234"), http.StatusUnauthorized)
+	is.Equal(request(http.MethodGet, "/", "", "10.0.0.1:1234"), http.StatusUnauthorized)
+	is.Equal(request(http.MethodGet, "/", "abc", "10.0.0.1:1234"), http.StatusTooManyRequests)
+	reader := signHS256(t, Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: "crm"}, Roles: []string{RoleReader}})
+	is.Equal(request(http.MethodGet, "/", reader, "10.0.0.1:1234"), http.StatusTooManyRequests)
+	is.Equal(request(http.MethodGet, "/", reader, "10.0.0.2:1234"), http.StatusOK)
+
+	// caller without the role is limited too
+	is.Equal(request(http.MethodGet, "/verify", reader, "10.0.0.2:1234"), http.StatusForbidden)
+	is.Equal(request(http.MethodGet, "/verify", reader, "10.0.0.2:1234"), http.StatusTooManyRequests)
+}
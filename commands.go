@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gopkg.in/yaml.v2"
+)
+
+// progressLogInterval is the interval of progress log lines of long running commands.
+const progressLogInterval = 10 * time.Second
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] [command]\n\n", os.Args[0])
+	fmt.Fprintln(out, "Commands:")
+	fmt.Fprintln(out, "  serve       start API server (default)")
+	fmt.Fprintln(out, "  recompute   score all summary pairs and store matchings")
+	fmt.Fprintln(out, "  dedupe      remove duplicated matchings of the same summary pair")
+	fmt.Fprintln(out, "  verify      report or remove matchings referencing missing summaries")
+	fmt.Fprintln(out, "  apikey      create, list, revoke or rotate API keys")
+	fmt.Fprintln(out, "  assign      pair two sets of summaries by stored matchings")
+	fmt.Fprintln(out, "  compare     compare matchings of two scorer versions")
+	fmt.Fprintln(out, "  config      print effective config with secrets redacted")
+	fmt.Fprintln(out, "\nFlags, also set by MATCHING_<FLAG> environment variables, e.g. MATCHING_DB_URI:")
+	flag.PrintDefaults()
+}
+
+// signalContext returns context which is cancelled on SIGINT or SIGTERM.
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			logger.Info("signal received, stopping", "signal", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(signals)
+	}()
+	return ctx, cancel
+}
+
+// runRecomputeCommand scores all summary pairs and waits until the job is finished.
+// With -namespace matchings are stored in the namespace of the scorer version, so they can be
+// compared with the current ones before reads are routed to them.
+// usage: int-matching -rules rules.json recompute [-resume] [-namespace]
+func runRecomputeCommand(config Config, args []string) error {
+	flags := flag.NewFlagSet("recompute", flag.ExitOnError)
+	resume := flags.Bool("resume", false, "skip summaries processed by the previous interrupted run")
+	namespace := flags.Bool("namespace", false, "store matchings in the namespace of the scorer version")
+	flags.Parse(args)
+
+	if config.ScoringRulesFile == "" {
+		return errors.New("recompute: scoring rules file is required, use -rules flag")
+	}
+
+	store, closeStore, err := openDataStore(config)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	scoring, err := newScoringEngine(config, store)
+	if err != nil {
+		return err
+	}
+
+	target := DataStore(store)
+	if *namespace {
+		version := scoring.Version()
+		if version == "" {
+			return errors.New("recompute: scorer has no version, namespace is not available")
+		}
+		if target, err = store.Namespace(version); err != nil {
+			return fmt.Errorf("recompute: %w", err)
+		}
+		if err := ensureIndexes(target); err != nil {
+			return err
+		}
+		logger.Info("recompute into namespace", "version", version)
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	job := NewRecomputeJob(scoring, target, config.RecomputeWorkers)
+	if err := job.Start(ctx, *resume); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(progressLogInterval)
+	defer ticker.Stop()
+	finished := make(chan error, 1)
+	go func() { finished <- job.Wait() }()
+	for {
+		select {
+		case <-ticker.C:
+			logRecomputeProgress(job.Progress())
+		case err := <-finished:
+			logRecomputeProgress(job.Progress())
+			return err
+		}
+	}
+}
+
+// runDedupeCommand removes duplicated matchings keeping the newest one of each summary pair
+// and creates unique summary pair index.
+// usage: int-matching dedupe [-dry-run]
+func runDedupeCommand(config Config, args []string) error {
+	flags := flag.NewFlagSet("dedupe", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "only report duplicates")
+	flags.Parse(args)
+
+	store, closeStore, err := openDataStore(config)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	result, err := store.DedupeMatchings(ctx, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	if *dryRun {
+		logger.Info("dedupe dry run", "pairs", result.Pairs, "duplicates", result.Removed)
+		return nil
+	}
+	logger.Info("dedupe done", "pairs", result.Pairs, "removed", result.Removed)
+	return ensureIndexes(store)
+}
+
+// runVerifyCommand reports matchings referencing missing summaries and removes them with -remove flag.
+// usage: int-matching verify [-remove]
+func runVerifyCommand(config Config, args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	remove := flags.Bool("remove", false, "remove orphan matchings")
+	flags.Parse(args)
+
+	store, closeStore, err := openDataStore(config)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	report, err := store.VerifyMatchings(ctx, *remove)
+	if err != nil {
+		return err
+	}
+
+	for _, orphan := range report.Orphans {
+		logger.Info("orphan matching",
+			"id", orphan.Id.Hex(),
+			"summary_id", orphan.SummaryId.Hex(),
+			"missing_summary", orphan.MissingSummary,
+			"matched_summary_id", orphan.MatchedSummaryId.Hex(),
+			"missing_matched_summary", orphan.MissingMatchedSummary)
+	}
+	logger.Info("verify done", "checked", report.Checked, "orphans", len(report.Orphans), "removed", report.Removed)
+	return nil
+}
+
+func logRecomputeProgress(p RecomputeProgress) {
+	logger.Info("recompute progress",
+		"state", p.State,
+		"summaries_done", p.SummariesDone,
+		"summaries_total", p.SummariesTotal,
+		"pairs_scored", p.PairsScored,
+		"pairs_written", p.PairsWritten,
+		"errors", p.Errors)
+}
+
+// runAPIKeyCommand manages API keys of service clients.
+// usage: int-matching apikey create -name crm-sync -scopes reader,writer [-rate-limit 600],
+// int-matching apikey list, int-matching apikey revoke|rotate <id>
+func runAPIKeyCommand(config Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("apikey: missing command, use create, list, revoke or rotate")
+	}
+
+	store, closeStore, err := openDataStore(config)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	ctx, cancel := AddTimeoutContext(context.Background())
+	defer cancel()
+	manager := NewAPIKeyManager(store)
+
+	var result interface{}
+	switch command := args[0]; command {
+	case "create":
+		flags := flag.NewFlagSet("apikey create", flag.ExitOnError)
+		name := flags.String("name", "", "name of the client")
+		scopes := flags.String("scopes", RoleReader, "comma separated scopes: reader, writer, admin")
+		rateLimit := flags.Int("rate-limit", 0, "max requests per minute, 0 means no limit")
+		flags.Parse(args[1:])
+		result, err = manager.Create(ctx, *name, strings.Split(*scopes, ","), *rateLimit)
+	case "list":
+		result, err = manager.List(ctx)
+	case "revoke", "rotate":
+		if len(args) != 2 {
+			return fmt.Errorf("apikey: %s requires key id", command)
+		}
+		id, idErr := primitive.ObjectIDFromHex(args[1])
+		if idErr != nil {
+			return fmt.Errorf("apikey: invalid key id %q", args[1])
+		}
+		if command == "revoke" {
+			result, err = manager.Revoke(ctx, id)
+		} else {
+			result, err = manager.Rotate(ctx, id)
+		}
+	default:
+		return fmt.Errorf("apikey: unknown command %q", command)
+	}
+	if err != nil {
+		return fmt.Errorf("apikey %s: %w", args[0], err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(result)
+}
+
+// runConfigCommand prints effective config, values from file, environment and flags included.
+// usage: int-matching -config config.yaml config print
+func runConfigCommand(config Config, args []string) error {
+	if len(args) != 1 || args[0] != "print" {
+		return errors.New("config: unknown command, use config print")
+	}
+
+	out, err := yaml.Marshal(config.Redacted())
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(out)
+	return err
+}
+
+// runAssignCommand pairs two sets of summaries by their stored matchings and prints the assignment.
+// usage: int-matching assign stable|optimal -proposers id,id -acceptors id,id [-capacity 2] [-capacities id=3] [-min-rate 50]
+func runAssignCommand(config Config, args []string) error {
+	methods := map[string]func(context.Context, MatchingStore, AssignmentRequest) (Assignment, error){
+		"stable":  StableAssignment,
+		"optimal": OptimalAssignment,
+	}
+	if len(args) == 0 || methods[args[0]] == nil {
+		return errors.New("assign: unknown method, use assign stable or assign optimal")
+	}
+
+	flags := flag.NewFlagSet("assign "+args[0], flag.ExitOnError)
+	proposers := flags.String("proposers", "", "comma separated summary ids proposing pairs")
+	acceptors := flags.String("acceptors", "", "comma separated summary ids accepting pairs")
+	capacity := flags.Int("capacity", 1, "number of proposers assigned to one acceptor")
+	capacities := flags.String("capacities", "", "comma separated id=capacity overrides of acceptors")
+	minRate := flags.Int("min-rate", 0, "min matchRate of the pair in both directions")
+	flags.Parse(args[1:])
+
+	request := AssignmentRequest{Capacity: *capacity, Capacities: make(map[string]int), MinRate: *minRate}
+	var err error
+	if request.Proposers, err = parseObjectIDList(*proposers); err != nil {
+		return fmt.Errorf("assign: proposers: %w", err)
+	}
+	if request.Acceptors, err = parseObjectIDList(*acceptors); err != nil {
+		return fmt.Errorf("assign: acceptors: %w", err)
+	}
+	for _, item := range strings.Split(*capacities, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("assign: invalid capacity %q", item)
+		}
+		if request.Capacities[parts[0]], err = strconv.Atoi(parts[1]); err != nil {
+			return fmt.Errorf("assign: invalid capacity %q", item)
+		}
+	}
+
+	store, closeStore, err := openDataStore(config)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	ctx, cancel := AddTimeoutContext(context.Background())
+	defer cancel()
+
+	assignment, err := methods[args[0]](ctx, store, request)
+	if err != nil {
+		return fmt.Errorf("assign %s: %w", args[0], err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(assignment)
+}
+
+// runCompareCommand compares matchings of two scorer version namespaces and prints the comparison.
+// Both namespaces are streamed by summary, so they are never loaded whole.
+// usage: int-matching compare -candidate v2 [-base default] [-k 10]
+func runCompareCommand(config Config, args []string) error {
+	flags := flag.NewFlagSet("compare", flag.ExitOnError)
+	base := flags.String("base", defaultNamespace, "scorer version of compared matchings")
+	candidate := flags.String("candidate", "", "scorer version compared with base")
+	topK := flags.Int("k", 10, "number of top matched summaries compared by overlap")
+	flags.Parse(args)
+
+	if *candidate == "" {
+		return errors.New("compare: candidate version is required, use -candidate flag")
+	}
+	if *topK < 0 {
+		return errors.New("compare: k must not be negative")
+	}
+
+	store, closeStore, err := openDataStore(config)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	iterate := func(version string) (MatchingsIterator, error) {
+		if err := checkNamespace(ctx, store, version); err != nil {
+			return nil, err
+		}
+		namespace, err := store.Namespace(version)
+		if err != nil {
+			return nil, err
+		}
+		return namespace.IterateMatchingsBySummary(ctx)
+	}
+	baseMatchings, err := iterate(*base)
+	if err != nil {
+		return fmt.Errorf("compare: base: %w", err)
+	}
+	defer baseMatchings.Close(ctx)
+	candidateMatchings, err := iterate(*candidate)
+	if err != nil {
+		return fmt.Errorf("compare: candidate: %w", err)
+	}
+	defer candidateMatchings.Close(ctx)
+
+	comparison, err := CompareVersionIterators(ctx, baseMatchings, candidateMatchings, *topK)
+	if err != nil {
+		return fmt.Errorf("compare: %w", err)
+	}
+	comparison.Base, comparison.Candidate = *base, *candidate
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(comparison)
+}
+
+// parseObjectIDList parses comma separated list of object ids.
+func parseObjectIDList(value string) ([]primitive.ObjectID, error) {
+	result := make([]primitive.ObjectID, 0)
+	for _, hex := range strings.Split(value, ",") {
+		if hex = strings.TrimSpace(hex); hex == "" {
+			continue
+		}
+		id, err := primitive.ObjectIDFromHex(hex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid summary id %q", hex)
+		}
+		result = append(result, id)
+	}
+	return result, nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gopkg.in/yaml.v2"
+)
+
+// envPrefix is the prefix of environment variables overriding the config,
+// flag -db-uri is set by MATCHING_DB_URI.
+const envPrefix = "MATCHING_"
+
+// redactedSecret replaces secrets in printed config.
+const redactedSecret = "xxxxx"
+
+// MongoURI returns connection URI of the database. Full DbURI takes precedence over DbHost and DbPort.
+func (sc Config) MongoURI() string {
+	if sc.DbURI != "" {
+		return sc.DbURI
+	}
+	return fmt.Sprintf("mongodb://%s:%s/?retryWrites=false", sc.DbHost, sc.DbPort)
+}
+
+// Validate checks that config values are usable before anything is started.
+func (sc Config) Validate() error {
+	if sc.DriverName != driverMongoDB && sc.DriverName != driverMemory {
+		return fmt.Errorf("config: unknown driver %q, use %s or %s", sc.DriverName, driverMongoDB, driverMemory)
+	}
+	if sc.Port <= 0 || sc.Port > 65535 {
+		return fmt.Errorf("config: port %d out of range", sc.Port)
+	}
+	if sc.ReadTimeout <= 0 || sc.WriteTimeout <= 0 || sc.ShutdownTimeout <= 0 {
+		return fmt.Errorf("config: read, write and shutdown timeouts must be positive")
+	}
+	if sc.RateLimits.Read < 0 || sc.RateLimits.Write < 0 || sc.RateLimits.Admin < 0 {
+		return fmt.Errorf("config: negative rate limit")
+	}
+	if _, err := ParseLevel(sc.LogLevel); err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	if err := sc.Versions.Validate(); err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	if sc.RecomputeWorkers < 0 {
+		return fmt.Errorf("config: negative number of recompute workers %d", sc.RecomputeWorkers)
+	}
+	if sc.DriverName == driverMemory {
+		return nil
+	}
+
+	if sc.DbName == "" {
+		return fmt.Errorf("config: database name is required")
+	}
+	if err := options.Client().ApplyURI(sc.MongoURI()).Validate(); err != nil {
+		return fmt.Errorf("config: invalid database uri %s: %w", redactURI(sc.MongoURI()), err)
+	}
+	return nil
+}
+
+// Redacted returns copy of the config safe to be printed or logged.
+func (sc Config) Redacted() Config {
+	if sc.DbURI != "" {
+		sc.DbURI = redactURI(sc.DbURI)
+	}
+	return sc
+}
+
+// redactURI hides the password of the connection URI. Unparsable URI is hidden completely.
+func redactURI(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return redactedSecret
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redactedSecret)
+	}
+	return u.String()
+}
+
+// registerConfigFlags binds config fields to the flags of the flag set.
+func registerConfigFlags(fs *flag.FlagSet, cfg *Config) {
+	fs.StringVar(&cfg.Host, "host", cfg.Host, "API server host")
+	fs.IntVar(&cfg.Port, "port", cfg.Port, "API server port")
+	fs.DurationVar(&cfg.ReadTimeout, "read-timeout", cfg.ReadTimeout, "API server read timeout")
+	fs.DurationVar(&cfg.WriteTimeout, "write-timeout", cfg.WriteTimeout, "API server write timeout")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", cfg.ShutdownTimeout, "time to drain requests and jobs on shutdown")
+	fs.StringVar(&cfg.DriverName, "driver", cfg.DriverName, "matching store driver: mongodb or memory")
+	fs.StringVar(&cfg.DbURI, "db-uri", cfg.DbURI, "full MongoDB connection URI, overrides db-host and db-port")
+	fs.StringVar(&cfg.DbHost, "db-host", cfg.DbHost, "MongoDB host")
+	fs.StringVar(&cfg.DbPort, "db-port", cfg.DbPort, "MongoDB port")
+	fs.StringVar(&cfg.DbName, "db-name", cfg.DbName, "MongoDB database name")
+	fs.StringVar(&cfg.ScoringRulesFile, "rules", cfg.ScoringRulesFile, "scoring rules file, scoring is disabled when empty")
+	fs.IntVar(&cfg.RecomputeWorkers, "workers", cfg.RecomputeWorkers, "number of recompute job workers, 0 means number of CPUs")
+	fs.BoolVar(&cfg.CheckSummaries, "check-summaries", cfg.CheckSummaries, "reject matchings referencing missing summaries")
+	fs.StringVar(&cfg.Auth.HMACKeyFile, "auth-hmac-key-file", cfg.Auth.HMACKeyFile, "file with HS256 token secret")
+	fs.StringVar(&cfg.Auth.PublicKeyFile, "auth-public-key-file", cfg.Auth.PublicKeyFile, "PEM file with RS256 token public key")
+	fs.StringVar(&cfg.Auth.JWKSFile, "auth-jwks-file", cfg.Auth.JWKSFile, "JWKS file with token verification keys")
+	fs.StringVar(&cfg.Auth.Issuer, "auth-issuer", cfg.Auth.Issuer, "required token issuer")
+	fs.StringVar(&cfg.Auth.Audience, "auth-audience", cfg.Auth.Audience, "required token audience")
+	fs.BoolVar(&cfg.APIKeys, "api-keys", cfg.APIKeys, "accept API keys managed by apikey command")
+	fs.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "minimal log level: debug, info, warn or error")
+	fs.IntVar(&cfg.RateLimits.Read, "rate-limit-read", cfg.RateLimits.Read, "max read requests per minute of a client, 0 means no limit")
+	fs.IntVar(&cfg.RateLimits.Write, "rate-limit-write", cfg.RateLimits.Write, "max write requests per minute of a client, 0 means no limit")
+	fs.IntVar(&cfg.RateLimits.Admin, "rate-limit-admin", cfg.RateLimits.Admin, "max admin requests per minute of a client, 0 means no limit")
+	fs.StringVar(&cfg.Versions.Default, "read-version", cfg.Versions.Default, "scorer version namespace serving reads, default namespace when empty")
+	fs.StringVar(&cfg.Versions.Candidate, "candidate-version", cfg.Versions.Candidate, "scorer version namespace serving reads of candidate-percent of profiles")
+	fs.IntVar(&cfg.Versions.Percent, "candidate-percent", cfg.Versions.Percent, "percent of profiles served by candidate-version")
+}
+
+// loadConfig builds the config from defaults, then YAML or JSON config file,
+// then environment variables and finally command line flags, each overriding the previous one.
+// Config file is selected by -config flag or MATCHING_CONFIG environment variable.
+func loadConfig(fs *flag.FlagSet, args []string, lookupEnv func(string) (string, bool)) (Config, error) {
+	cfg := newConfig()
+	configFile := fs.String("config", "", "YAML or JSON config file")
+	registerConfigFlags(fs, &cfg)
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	// flags are applied last, remember them and start over from defaults
+	flagValues := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		flagValues[f.Name] = f.Value.String()
+	})
+	cfg = newConfig()
+
+	path := *configFile
+	if path == "" {
+		path, _ = lookupEnv(envPrefix + "CONFIG")
+	}
+	if path != "" {
+		if err := readConfigFile(path, &cfg); err != nil {
+			return cfg, err
+		}
+	}
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		name := envName(f.Name)
+		value, ok := lookupEnv(name)
+		if !ok || f.Name == "config" || err != nil {
+			return
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("config: invalid %s: %w", name, setErr)
+		}
+	})
+	if err != nil {
+		return cfg, err
+	}
+
+	for name, value := range flagValues {
+		if err := fs.Set(name, value); err != nil {
+			return cfg, err
+		}
+	}
+	return cfg, cfg.Validate()
+}
+
+// readConfigFile overrides config with values of YAML or JSON file, JSON is read as YAML.
+func readConfigFile(path string, cfg *Config) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return fmt.Errorf("config: %s: %w", path, err)
+	}
+	return nil
+}
+
+// envName returns environment variable name of the flag, db-uri becomes MATCHING_DB_URI.
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strings"
+	"time"
+)
+
+// Scopes of the erasure request.
+const (
+	ErasureScopeSummary = "summary"
+	ErasureScopeProfile = "profile"
+)
+
+// States of the erasure audit record.
+const (
+	ErasureStatusPending = "pending"
+	ErasureStatusDone    = "done"
+	ErasureStatusFailed  = "failed"
+)
+
+// ErasureAudit records removal of all matchings of a summary or of a profile.
+// Only ids are recorded, so the audit record itself holds no personal data.
+type ErasureAudit struct {
+	Id               primitive.ObjectID   `json:"id" bson:"_id"`
+	Scope            string               `json:"scope" bson:"scope"`
+	SubjectId        primitive.ObjectID   `json:"subjectId" bson:"subjectId"`
+	SummaryIds       []primitive.ObjectID `json:"summaryIds" bson:"summaryIds"`
+	DeletedMatchings int64                `json:"deletedMatchings" bson:"deletedMatchings"`
+	// Namespaces are deleted matchings by scorer version namespace, including default.
+	Namespaces map[string]int64 `json:"namespaces,omitempty" bson:"namespaces,omitempty"`
+	// Status is pending until matchings are deleted, failed erasure keeps the error.
+	Status    string    `json:"status" bson:"status"`
+	Error     string    `json:"error,omitempty" bson:"error,omitempty"`
+	RequestId string    `json:"requestId,omitempty" bson:"requestId,omitempty"`
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+}
+
+// ErasureStore removes matchings of deleted summaries and keeps audit of the removals.
+type ErasureStore interface {
+	// GetSummaryIdsByProfile returns ids of all summaries of the profile.
+	GetSummaryIdsByProfile(ctx context.Context, profileID primitive.ObjectID) ([]primitive.ObjectID, error)
+	// DeleteMatchingsBySummaries removes matchings referencing any of passed summaries in either direction.
+	DeleteMatchingsBySummaries(ctx context.Context, summaryIDs []primitive.ObjectID) (int64, error)
+	// SaveErasureAudit stores the audit record or replaces the stored one of the same id.
+	SaveErasureAudit(ctx context.Context, audit ErasureAudit) error
+}
+
+// eraseMatchings removes every matching touching passed summaries in all namespaces.
+// Pending audit record is stored before anything is deleted and completed afterwards,
+// so an erasure is never left without a record, even when it fails part-way.
+func eraseMatchings(ctx context.Context, store DataStore, audit ErasureAudit) (ErasureAudit, error) {
+	audit.Id = primitive.NewObjectID()
+	audit.Status = ErasureStatusPending
+	audit.CreatedAt = time.Now().UTC().Truncate(time.Millisecond)
+	if err := store.SaveErasureAudit(ctx, audit); err != nil {
+		return audit, err
+	}
+
+	err := deleteNamespacesMatchings(ctx, store, &audit)
+	audit.Status = ErasureStatusDone
+	if err != nil {
+		audit.Status = ErasureStatusFailed
+		audit.Error = err.Error()
+	}
+
+	// record is completed even when the request was cancelled meanwhile
+	saveCtx, cancel := AddTimeoutContext(context.Background())
+	defer cancel()
+	if saveErr := store.SaveErasureAudit(saveCtx, audit); saveErr != nil && err == nil {
+		err = saveErr
+	}
+	return audit, err
+}
+
+// deleteNamespacesMatchings removes matchings of the audited summaries in the default namespace
+// and in every scorer version namespace and records deleted counts in the audit. Failed namespace
+// doesn't stop the others, so the audit records how far the erasure got.
+func deleteNamespacesMatchings(ctx context.Context, store DataStore, audit *ErasureAudit) error {
+	audit.Namespaces = make(map[string]int64)
+	var failed []string
+	var firstErr error
+	erase := func(version string, namespace ErasureStore, err error) {
+		var deleted int64
+		if err == nil {
+			deleted, err = namespace.DeleteMatchingsBySummaries(ctx, audit.SummaryIds)
+		}
+		audit.Namespaces[version] = deleted
+		audit.DeletedMatchings += deleted
+		if err != nil {
+			failed = append(failed, version)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	erase(defaultNamespace, store, nil)
+	versions, err := store.Namespaces(ctx)
+	if err != nil {
+		failed = append(failed, "list namespaces")
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	for _, version := range versions {
+		namespace, err := store.Namespace(version)
+		erase(version, namespace, err)
+	}
+
+	if firstErr != nil {
+		return fmt.Errorf("erase %s: %w", strings.Join(failed, ", "), firstErr)
+	}
+	return nil
+}
@@ -5,7 +5,10 @@ go 1.15
 require (
 	github.com/go-chi/chi v4.1.2+incompatible
 	github.com/go-chi/cors v1.1.1
+	github.com/golang-jwt/jwt/v4 v4.5.2
 	github.com/matryer/is v1.4.0
 	github.com/pkg/errors v0.9.1
+	github.com/prometheus/client_golang v1.11.1
 	go.mongodb.org/mongo-driver v1.4.1
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -1,24 +1,110 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"github.com/go-chi/chi/middleware"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io/ioutil"
+	"mime"
 	"net/http"
+	"strconv"
 )
 
-// getMatchingsHandler is a handler function to return list of matchings
-// endpoint: GET /api/v1/matching
+// maxBulkItems limits the number of matchings accepted by a single bulk request.
+const maxBulkItems = 10000
+
+// getMatchingsHandler is a handler function to return a page of matchings
+// endpoint: GET /api/v1/matching?summaryId=&matchedSummaryId=&minRate=&maxRate=&createdFrom=&createdTo=&sort=-matchRate&limit=100&after=
+// Total number of matchings is returned in X-Total-Count header, cursor of the next page in X-Next-Cursor header.
 func (s *Server) getMatchingsHandler(w http.ResponseWriter, r *http.Request) {
-	tagGroups, err := s.repo.GetAllMatchings(r.Context())
+	query, err := parseMatchingsQuery(r)
 	if err != nil {
-		RespondError(w, r, http.StatusInternalServerError, err)
+		RespondError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	// caller acting for a profile lists matchings of its own summary only
+	if claims, ok := ClaimsFromContext(r.Context()); ok && claims.ProfileId != "" {
+		if query.SummaryId.IsZero() {
+			RespondError(w, r, http.StatusForbidden, "summaryId filter required")
+			return
+		}
+		if err := s.authorizeSummary(r.Context(), query.SummaryId); err != nil {
+			respondStoreError(w, r, err)
+			return
+		}
+	}
+
+	explain, err := URLQueryBool(r, "explain")
+	if err != nil {
+		RespondError(w, r, http.StatusBadRequest, err)
 		return
 	}
 
-	Respond(w, r, http.StatusOK, tagGroups)
+	store, err := s.readStore(w, r, query.SummaryId)
+	if err != nil {
+		respondStoreError(w, r, err)
+		return
+	}
+	page, err := store.FindMatchings(r.Context(), query)
+	if err != nil {
+		respondStoreError(w, r, err)
+		return
+	}
+	explainMatchings(page.Matchings, explain)
+
+	w.Header().Set(headerTotalCount, strconv.FormatInt(page.Total, 10))
+	if page.Next != "" {
+		w.Header().Set(headerNextCursor, page.Next)
+	}
+	Respond(w, r, http.StatusOK, page.Matchings)
 }
 
-// getMatchingsHandler is a handler function to return list of matchings
-// endpoint: GET /api/v1/matching/summary/{summaryId}
+func parseMatchingsQuery(r *http.Request) (MatchingsQuery, error) {
+	values := r.URL.Query()
+	query := MatchingsQuery{
+		Sort:  values.Get("sort"),
+		After: values.Get("after"),
+	}
+
+	var err error
+	if query.Limit, err = URLQueryInt(r, "limit", 0); err != nil || query.Limit < 0 {
+		return query, errors.New("invalid request data limit")
+	}
+	if query.SummaryId, err = URLQueryObjectID(r, "summaryId"); err != nil {
+		return query, err
+	}
+	if query.MatchedSummaryId, err = URLQueryObjectID(r, "matchedSummaryId"); err != nil {
+		return query, err
+	}
+	if values.Get("minRate") != "" {
+		minRate, err := URLQueryInt(r, "minRate", 0)
+		if err != nil {
+			return query, err
+		}
+		query.MinRate = &minRate
+	}
+	if values.Get("maxRate") != "" {
+		maxRate, err := URLQueryInt(r, "maxRate", 0)
+		if err != nil {
+			return query, err
+		}
+		query.MaxRate = &maxRate
+	}
+	if query.CreatedFrom, err = URLQueryTime(r, "createdFrom"); err != nil {
+		return query, err
+	}
+	if query.CreatedTo, err = URLQueryTime(r, "createdTo"); err != nil {
+		return query, err
+	}
+	return query, nil
+}
+
+// getMatchingHandler is a handler function to return matchings of the summary ranked by matchRate
+// endpoint: GET /api/v1/matching/summary/{summaryId}?limit=10&minRate=50&exclude={summaryId},{summaryId}
 func (s *Server) getMatchingHandler(w http.ResponseWriter, r *http.Request) {
 	summaryID, err := URLParamObjectID(r, "summaryId")
 	if err != nil {
@@ -26,43 +112,464 @@ func (s *Server) getMatchingHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	matching, err := s.repo.GetMatchingBySummaryId(r.Context(), summaryID)
+	query, err := parseSummaryMatchingsQuery(r)
 	if err != nil {
-		RespondError(w, r, http.StatusInternalServerError, err)
+		RespondError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	explain, err := URLQueryBool(r, "explain")
+	if err != nil {
+		RespondError(w, r, http.StatusBadRequest, err)
 		return
 	}
 
-	Respond(w, r, http.StatusOK, matching)
+	if err := s.authorizeSummary(r.Context(), summaryID); err != nil {
+		respondStoreError(w, r, err)
+		return
+	}
+
+	store, err := s.readStore(w, r, summaryID)
+	if err != nil {
+		respondStoreError(w, r, err)
+		return
+	}
+	matchings, err := store.GetMatchingsBySummaryId(r.Context(), summaryID, query)
+	if err != nil {
+		respondStoreError(w, r, err)
+		return
+	}
+	explainMatchings(matchings, explain)
+
+	Respond(w, r, http.StatusOK, matchings)
+}
+
+// explainMatchings removes score breakdown of matchings unless explain is requested.
+func explainMatchings(matchings []*Matching, explain bool) {
+	if explain {
+		return
+	}
+	for _, matching := range matchings {
+		matching.Breakdown = nil
+	}
+}
+
+func parseSummaryMatchingsQuery(r *http.Request) (SummaryMatchingsQuery, error) {
+	query := SummaryMatchingsQuery{}
+	var err error
+	if query.Limit, err = URLQueryInt(r, "limit", 0); err != nil || query.Limit < 0 {
+		return query, errors.New("invalid request data limit")
+	}
+	if query.MinRate, err = URLQueryInt(r, "minRate", 0); err != nil {
+		return query, err
+	}
+	if query.Exclude, err = URLQueryObjectIDs(r, "exclude"); err != nil {
+		return query, err
+	}
+	return query, nil
+}
+
+// deleteSummaryMatchingsHandler removes every matching of the summary in both directions
+// endpoint: DELETE /api/v1/matching/summary/{summaryId}
+func (s *Server) deleteSummaryMatchingsHandler(w http.ResponseWriter, r *http.Request) {
+	summaryID, err := URLParamObjectID(r, "summaryId")
+	if err != nil {
+		RespondError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	audit, err := eraseMatchings(r.Context(), s.store, ErasureAudit{
+		Scope:      ErasureScopeSummary,
+		SubjectId:  summaryID,
+		SummaryIds: []primitive.ObjectID{summaryID},
+		RequestId:  middleware.GetReqID(r.Context()),
+	})
+	if err != nil {
+		respondStoreError(w, r, err)
+		return
+	}
+
+	Respond(w, r, http.StatusOK, audit)
 }
 
-// postMatchingsBulkHandler save new
+// deleteProfileMatchingsHandler removes every matching of all summaries of the profile in both directions
+// endpoint: DELETE /api/v1/matching/profile/{profileId}
+func (s *Server) deleteProfileMatchingsHandler(w http.ResponseWriter, r *http.Request) {
+	profileID, err := URLParamObjectID(r, "profileId")
+	if err != nil {
+		RespondError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	summaryIDs, err := s.store.GetSummaryIdsByProfile(r.Context(), profileID)
+	if err != nil {
+		respondStoreError(w, r, err)
+		return
+	}
+
+	audit, err := eraseMatchings(r.Context(), s.store, ErasureAudit{
+		Scope:      ErasureScopeProfile,
+		SubjectId:  profileID,
+		SummaryIds: summaryIDs,
+		RequestId:  middleware.GetReqID(r.Context()),
+	})
+	if err != nil {
+		respondStoreError(w, r, err)
+		return
+	}
+
+	Respond(w, r, http.StatusOK, audit)
+}
+
+// postMatchingHandler saves new matching or updates existing one
 // endpoint: POST /api/v1/matching
-// payload:
+// payload: matching, matching without id updates already stored matching of the same summary pair
 func (s *Server) postMatchingHandler(w http.ResponseWriter, r *http.Request) {
-	matching := Matching{}
-	if err := DecodeJSON(r.Body, &matching); err != nil {
+	matching, err := decodeMatchingBody(r.Body)
+	if err != nil {
+		respondDecodeError(w, r, err)
+		return
+	}
+
+	if primitive.NilObjectID == matching.Id {
+		// matching of already stored summary pair is updated
+		id, err := s.repo.CreateMatching(r.Context(), matching)
+		if err != nil {
+			respondStoreError(w, r, err)
+			return
+		}
+		matching.Id = id
+		Respond(w, r, http.StatusOK, matching)
+		return
+	}
+
+	if _, err := s.repo.UpdateMatching(r.Context(), matching); err != nil {
+		respondStoreError(w, r, err)
+		return
+	}
+
+	Respond(w, r, http.StatusOK, matching)
+}
+
+// postMatchingsBulkHandler saves a batch of matchings and reports status of every item.
+// endpoint: POST /api/v1/matching/bulk
+// payload: JSON array of matchings, or NDJSON stream when Content-Type is application/x-ndjson
+func (s *Server) postMatchingsBulkHandler(w http.ResponseWriter, r *http.Request) {
+	items, err := DecodeJSONItems(r, maxBulkItems)
+	if errors.Is(err, errTooManyItems) {
+		err := fmt.Errorf("bulk request has more than %d items", maxBulkItems)
+		RespondError(w, r, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+	if err != nil {
 		RespondError(w, r, http.StatusBadRequest, err)
 		return
 	}
 
-	modCount, err := s.repo.UpdateMatching(r.Context(), matching)
+	if len(items) == 0 {
+		RespondError(w, r, http.StatusBadRequest, "empty bulk request")
+		return
+	}
+
+	results := make([]BulkItemResult, len(items))
+	matchings := make([]Matching, 0, len(items))
+	indexes := make([]int, 0, len(items))
+	for i, item := range items {
+		matching, err := decodeMatching(item)
+		if err != nil {
+			results[i] = BulkItemResult{Index: i, Status: BulkStatusFailed, Error: err.Error()}
+			if verr, ok := err.(*ValidationError); ok {
+				results[i].Fields = verr.Fields
+			}
+			continue
+		}
+		matchings = append(matchings, matching)
+		indexes = append(indexes, i)
+	}
+
+	written, err := s.repo.BulkWriteMatchings(r.Context(), matchings)
 	if err != nil {
-		RespondError(w, r, http.StatusInternalServerError, err)
+		respondStoreError(w, r, err)
 		return
 	}
 
-	if modCount == 0 {
-		err := errors.New("Nothing was changed.")
+	for i, result := range written {
+		result.Index = indexes[i]
+		results[result.Index] = result
+	}
+
+	Respond(w, r, http.StatusOK, results)
+}
+
+// getMatchingByIdHandler is a handler function to return single matching
+// endpoint: GET /api/v1/matching/{id}
+func (s *Server) getMatchingByIdHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := URLParamObjectID(r, "id")
+	if err != nil {
+		RespondError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	explain, err := URLQueryBool(r, "explain")
+	if err != nil {
+		RespondError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	store, err := s.readStore(w, r, primitive.NilObjectID)
+	if err != nil {
+		respondStoreError(w, r, err)
+		return
+	}
+	matching, err := store.GetMatching(r.Context(), id)
+	if err != nil {
+		respondStoreError(w, r, err)
+		return
+	}
+	if err := s.authorizeSummary(r.Context(), matching.SummaryId); err != nil {
+		respondStoreError(w, r, err)
+		return
+	}
+	explainMatchings([]*Matching{&matching}, explain)
+
+	Respond(w, r, http.StatusOK, matching)
+}
+
+// putMatchingHandler replaces existing matching
+// endpoint: PUT /api/v1/matching/{id}
+// payload: matching, id in payload is optional but has to match id in URL
+func (s *Server) putMatchingHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := URLParamObjectID(r, "id")
+	if err != nil {
+		RespondError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	matching, err := decodeMatchingBody(r.Body)
+	if err != nil {
+		respondDecodeError(w, r, err)
+		return
+	}
+
+	if matching.Id != primitive.NilObjectID && matching.Id != id {
+		RespondError(w, r, http.StatusBadRequest, "matching id does not match URL id")
+		return
+	}
+	matching.Id = id
+
+	if _, err := s.repo.UpdateMatching(r.Context(), matching); err != nil {
+		respondStoreError(w, r, err)
+		return
+	}
+
+	Respond(w, r, http.StatusOK, matching)
+}
+
+// patchMatchingHandler partially updates existing matching
+// endpoint: PATCH /api/v1/matching/{id}
+// payload: JSON Merge Patch (RFC 7386) document, e.g. {"matchRate": 40}
+func (s *Server) patchMatchingHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := URLParamObjectID(r, "id")
+	if err != nil {
+		RespondError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if contentType := r.Header.Get(headerContentType); contentType != "" {
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		if mediaType != mimeMergePatchJSON && mediaType != mimeApplicationJSON {
+			RespondError(w, r, http.StatusUnsupportedMediaType, "unsupported patch content type ", mediaType)
+			return
+		}
+	}
+
+	patch, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		RespondError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	current, err := s.repo.GetMatching(r.Context(), id)
+	if err != nil {
+		respondStoreError(w, r, err)
+		return
+	}
+
+	doc, err := json.Marshal(current)
+	if err != nil {
 		RespondError(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
+	patched, err := MergePatch(doc, patch)
+	if err != nil {
+		RespondError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	matching, err := decodeMatching(patched)
+	if err != nil {
+		respondDecodeError(w, r, err)
+		return
+	}
+	matching.Id = id
+	// scorer version and breakdown explain the stored matchRate, they are dropped when matchRate is patched without them
+	patchFields := make(map[string]json.RawMessage)
+	_ = json.Unmarshal(patch, &patchFields)
+	if matching.MatchRate != current.MatchRate {
+		if _, ok := patchFields["breakdown"]; !ok {
+			matching.Breakdown = nil
+		}
+		if _, ok := patchFields["scorerVersion"]; !ok {
+			matching.ScorerVersion = ""
+		}
+	}
+
+	if _, err := s.repo.UpdateMatching(r.Context(), matching); err != nil {
+		respondStoreError(w, r, err)
+		return
+	}
+
 	Respond(w, r, http.StatusOK, matching)
 }
 
-// postMatchingsBulkHandler save new
-// endpoint: POST /api/v1/matching/bulk
-// payload:
-func (s *Server) postMatchingsBulkHandler(w http.ResponseWriter, r *http.Request) {
-	//decodeRequest()
+// deleteMatchingHandler removes matching
+// endpoint: DELETE /api/v1/matching/{id}
+func (s *Server) deleteMatchingHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := URLParamObjectID(r, "id")
+	if err != nil {
+		RespondError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := s.repo.DeleteMatching(r.Context(), id); err != nil {
+		respondStoreError(w, r, err)
+		return
+	}
+
+	Respond(w, r, http.StatusNoContent, nil)
+}
+
+// scoreRequest is the payload of the score endpoint.
+type scoreRequest struct {
+	SummaryId        primitive.ObjectID `json:"summaryId"`
+	MatchedSummaryId primitive.ObjectID `json:"matchedSummaryId"`
+}
+
+// postScoreHandler computes matching of two summaries with configured scorer
+// endpoint: POST /api/v1/matching/score?save=true
+// payload: {"summaryId": "...", "matchedSummaryId": "..."}
+// Computed matching is stored only when save=true.
+func (s *Server) postScoreHandler(w http.ResponseWriter, r *http.Request) {
+	if s.scoring == nil {
+		RespondError(w, r, http.StatusNotImplemented, "scoring is not configured")
+		return
+	}
+
+	req := scoreRequest{}
+	if err := DecodeJSON(r.Body, &req); err != nil {
+		RespondError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if req.SummaryId == primitive.NilObjectID || req.MatchedSummaryId == primitive.NilObjectID {
+		RespondError(w, r, http.StatusBadRequest, "summaryId and matchedSummaryId are required")
+		return
+	}
+
+	matching, err := s.scoring.Score(r.Context(), req.SummaryId, req.MatchedSummaryId)
+	if err != nil {
+		respondStoreError(w, r, err)
+		return
+	}
+
+	if r.URL.Query().Get("save") == "true" {
+		id, err := s.repo.CreateMatching(r.Context(), matching)
+		if err != nil {
+			respondStoreError(w, r, err)
+			return
+		}
+		matching.Id = id
+	}
+
+	Respond(w, r, http.StatusOK, matching)
+}
+
+// getRecomputeJobHandler returns progress of the recompute job
+// endpoint: GET /api/v1/matching/jobs/recompute
+func (s *Server) getRecomputeJobHandler(w http.ResponseWriter, r *http.Request) {
+	if s.recompute == nil {
+		RespondError(w, r, http.StatusNotImplemented, "scoring is not configured")
+		return
+	}
+
+	Respond(w, r, http.StatusOK, s.recompute.Progress())
+}
+
+// postRecomputeJobHandler starts the recompute job of all summary pairs in background
+// endpoint: POST /api/v1/matching/jobs/recompute?resume=true
+func (s *Server) postRecomputeJobHandler(w http.ResponseWriter, r *http.Request) {
+	if s.recompute == nil {
+		RespondError(w, r, http.StatusNotImplemented, "scoring is not configured")
+		return
+	}
+
+	resume := r.URL.Query().Get("resume") == "true"
+	// job outlives the request, so it is not started with request context
+	if err := s.recompute.Start(context.Background(), resume); err != nil {
+		respondStoreError(w, r, err)
+		return
+	}
+
+	Respond(w, r, http.StatusAccepted, s.recompute.Progress())
+}
+
+// deleteRecomputeJobHandler cancels running recompute job, it can be resumed later
+// endpoint: DELETE /api/v1/matching/jobs/recompute
+func (s *Server) deleteRecomputeJobHandler(w http.ResponseWriter, r *http.Request) {
+	if s.recompute == nil {
+		RespondError(w, r, http.StatusNotImplemented, "scoring is not configured")
+		return
+	}
+
+	s.recompute.Cancel()
+	Respond(w, r, http.StatusAccepted, s.recompute.Progress())
+}
+
+// getVerifyHandler reports matchings referencing summaries which do not exist
+// endpoint: GET /api/v1/matching/verify
+func (s *Server) getVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	report, err := s.store.VerifyMatchings(r.Context(), false)
+	if err != nil {
+		respondStoreError(w, r, err)
+		return
+	}
+
+	Respond(w, r, http.StatusOK, report)
+}
+
+// postVerifyHandler removes matchings referencing summaries which do not exist
+// endpoint: POST /api/v1/matching/verify
+func (s *Server) postVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	report, err := s.store.VerifyMatchings(r.Context(), true)
+	if err != nil {
+		respondStoreError(w, r, err)
+		return
+	}
+
+	Respond(w, r, http.StatusOK, report)
+}
+
+// respondStoreError responds with status matching the MatchingStore error.
+// Errors other than store sentinel errors are reported as database being unavailable.
+func respondStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusServiceUnavailable
+	switch {
+	case errors.Is(err, ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrConflict):
+		status = http.StatusConflict
+	case errors.Is(err, ErrInvalid):
+		status = http.StatusBadRequest
+	case errors.Is(err, ErrForbidden):
+		status = http.StatusForbidden
+	}
+	RespondError(w, r, status, err)
 }
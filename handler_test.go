@@ -0,0 +1,596 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	iss "github.com/matryer/is"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestServer(t *testing.T) (*Server, *MemoryStore) {
+	store := NewMemoryStore()
+	return NewServer("test", store), store
+}
+
+// doRequest serves the request by router of the server, headers are pairs of name and value.
+func doRequest(t *testing.T, s *Server, method, target string, body []byte, headers ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	if len(headers)%2 != 0 {
+		t.Fatalf("header %q has no value", headers[len(headers)-1])
+	}
+	req := httptest.NewRequest(method, target, bytes.NewReader(body))
+	for i := 0; i < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	s.Router.ServeHTTP(w, req)
+	return w
+}
+
+func TestServer_getMatchingsHandler(t *testing.T) {
+	is := iss.New(t)
+	s, store := newTestServer(t)
+	_, err := store.CreateMatching(context.Background(), Matching{
+		SummaryId:        makeObjectId(t, summaryId1),
+		MatchedSummaryId: makeObjectId(t, summaryId2),
+		MatchRate:        15,
+	})
+	is.NoErr(err)
+
+	w := doRequest(t, s, http.MethodGet, "/", nil)
+	is.Equal(w.Code, http.StatusOK)
+
+	var matchings []Matching
+	is.NoErr(json.NewDecoder(w.Body).Decode(&matchings))
+	is.Equal(len(matchings), 1)
+	is.Equal(matchings[0].MatchRate, 15)
+}
+
+func TestServer_postMatchingsBulkHandler(t *testing.T) {
+	is := iss.New(t)
+	s, store := newTestServer(t)
+
+	body := []byte(`[
+		{"summaryId":"` + summaryId1 + `","matchedSummaryId":"` + summaryId2 + `","matchRate":15},
+		{"summaryId":"not-an-id"},
+		{"summaryId":"` + summaryId2 + `","matchedSummaryId":"` + summaryId1 + `","matchRate":25}
+	]`)
+	w := doRequest(t, s, http.MethodPost, "/bulk", body)
+	is.Equal(w.Code, http.StatusOK)
+
+	var results []BulkItemResult
+	is.NoErr(json.NewDecoder(w.Body).Decode(&results))
+	is.Equal(len(results), 3)
+	is.Equal(results[0].Status, BulkStatusCreated)
+	is.Equal(results[1].Status, BulkStatusFailed)
+	is.True(results[1].Error != "")
+	is.Equal(results[2].Status, BulkStatusCreated)
+	is.True(results[2].Id != primitive.NilObjectID)
+
+	matchings, err := store.GetAllMatchings(context.Background())
+	is.NoErr(err)
+	is.Equal(len(matchings), 2)
+}
+
+func TestServer_postMatchingsBulkHandler_limits(t *testing.T) {
+	s, _ := newTestServer(t)
+	item := `{"summaryId":"` + summaryId1 + `","matchedSummaryId":"` + summaryId2 + `","matchRate":15}`
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+		wantCode    int
+	}{
+		{name: "too many array items", body: []byte("[" + strings.Repeat(item+",", maxBulkItems) + item + "]"), wantCode: http.StatusRequestEntityTooLarge},
+		{name: "too many ndjson items", contentType: mimeApplicationNDJSON, body: []byte(strings.Repeat(item+"\n", maxBulkItems+1)), wantCode: http.StatusRequestEntityTooLarge},
+		{name: "body too large", body: []byte(`[{"summaryId":"` + strings.Repeat("x", maxBulkBodySize) + `"}]`), wantCode: http.StatusBadRequest},
+		{name: "not an array", body: []byte(item), wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := iss.New(t)
+			w := doRequest(t, s, http.MethodPost, "/bulk", tt.body, headerContentType, tt.contentType)
+			is.Equal(w.Code, tt.wantCode)
+		})
+	}
+}
+
+func TestServer_getMatchingHandler(t *testing.T) {
+	is := iss.New(t)
+	s, store := newTestServer(t)
+	ctx := context.Background()
+	summary := makeObjectId(t, summaryId1)
+	rates := []int{15, 70, 40, 90}
+	matched := make([]primitive.ObjectID, len(rates))
+	for i, rate := range rates {
+		matched[i] = primitive.NewObjectID()
+		_, err := store.CreateMatching(ctx, Matching{SummaryId: summary, MatchedSummaryId: matched[i], MatchRate: rate})
+		is.NoErr(err)
+	}
+
+	tests := []struct {
+		name      string
+		target    string
+		wantRates []int
+	}{
+		{name: "all ranked", target: "/summary/" + summaryId1, wantRates: []int{90, 70, 40, 15}},
+		{name: "limit", target: "/summary/" + summaryId1 + "?limit=2", wantRates: []int{90, 70}},
+		{name: "min rate", target: "/summary/" + summaryId1 + "?minRate=40", wantRates: []int{90, 70, 40}},
+		{name: "exclude", target: "/summary/" + summaryId1 + "?exclude=" + matched[3].Hex(), wantRates: []int{70, 40, 15}},
+		{name: "other summary", target: "/summary/" + summaryId2, wantRates: []int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := iss.New(t)
+			w := doRequest(t, s, http.MethodGet, tt.target, nil)
+			is.Equal(w.Code, http.StatusOK)
+
+			var matchings []Matching
+			is.NoErr(json.NewDecoder(w.Body).Decode(&matchings))
+			gotRates := make([]int, 0)
+			for _, m := range matchings {
+				gotRates = append(gotRates, m.MatchRate)
+			}
+			is.Equal(gotRates, tt.wantRates)
+		})
+	}
+
+	w := doRequest(t, s, http.MethodGet, "/summary/"+summaryId1+"?limit=-1", nil)
+	is.Equal(w.Code, http.StatusBadRequest)
+}
+
+func TestServer_getMutualMatchingsHandler(t *testing.T) {
+	is := iss.New(t)
+	s, store := newTestServer(t)
+	ctx := context.Background()
+	summary := makeObjectId(t, summaryId1)
+	pairs := []struct{ rate, reverseRate int }{{80, 40}, {60, 60}, {90, 0}, {50, 100}}
+	matched := make([]primitive.ObjectID, len(pairs))
+	for i, pair := range pairs {
+		matched[i] = primitive.NewObjectID()
+		_, err := store.CreateMatching(ctx, Matching{SummaryId: summary, MatchedSummaryId: matched[i], MatchRate: pair.rate})
+		is.NoErr(err)
+		if pair.reverseRate > 0 {
+			_, err = store.CreateMatching(ctx, Matching{SummaryId: matched[i], MatchedSummaryId: summary, MatchRate: pair.reverseRate})
+			is.NoErr(err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		wantIds    []primitive.ObjectID
+		wantScores []float64
+	}{
+		{name: "min by default", query: "", wantIds: []primitive.ObjectID{matched[1], matched[3], matched[0]}, wantScores: []float64{60, 50, 40}},
+		{name: "harmonic", query: "?combine=harmonic", wantIds: []primitive.ObjectID{matched[3], matched[1], matched[0]}},
+		{name: "product", query: "?combine=product", wantIds: []primitive.ObjectID{matched[3], matched[1], matched[0]}, wantScores: []float64{50, 36, 32}},
+		{name: "min rate of both directions", query: "?minRate=50", wantIds: []primitive.ObjectID{matched[1], matched[3]}},
+		{name: "limit", query: "?limit=1", wantIds: []primitive.ObjectID{matched[1]}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := iss.New(t)
+			w := doRequest(t, s, http.MethodGet, "/summary/"+summaryId1+"/mutual"+tt.query, nil)
+			is.Equal(w.Code, http.StatusOK)
+
+			var matchings []MutualMatching
+			is.NoErr(json.NewDecoder(w.Body).Decode(&matchings))
+			gotIds := make([]primitive.ObjectID, 0)
+			gotScores := make([]float64, 0)
+			for _, m := range matchings {
+				is.Equal(m.SummaryId, summary)
+				gotIds = append(gotIds, m.MatchedSummaryId)
+				gotScores = append(gotScores, m.Score)
+			}
+			is.Equal(gotIds, tt.wantIds)
+			if tt.wantScores != nil {
+				is.Equal(gotScores, tt.wantScores)
+			}
+		})
+	}
+
+	w := doRequest(t, s, http.MethodGet, "/summary/"+summaryId1+"/mutual?combine=max", nil)
+	is.Equal(w.Code, http.StatusBadRequest)
+}
+
+func TestServer_postMatchingHandler_notFound(t *testing.T) {
+	is := iss.New(t)
+	s, _ := newTestServer(t)
+
+	body := []byte(`{"id":"` + primitive.NewObjectID().Hex() + `","summaryId":"` + summaryId1 +
+		`","matchedSummaryId":"` + summaryId2 + `","matchRate":15}`)
+	w := doRequest(t, s, http.MethodPost, "/", body)
+	is.Equal(w.Code, http.StatusNotFound)
+
+	w = doRequest(t, s, http.MethodPost, "/", []byte(`{"summaryId":`))
+	is.Equal(w.Code, http.StatusBadRequest)
+}
+
+func TestServer_matchingResource(t *testing.T) {
+	is := iss.New(t)
+	s, store := newTestServer(t)
+	matchingId, err := store.CreateMatching(context.Background(), Matching{
+		SummaryId:        makeObjectId(t, summaryId1),
+		MatchedSummaryId: makeObjectId(t, summaryId2),
+		MatchRate:        15,
+	})
+	is.NoErr(err)
+	id := matchingId.Hex()
+
+	w := doRequest(t, s, http.MethodGet, "/"+id, nil)
+	is.Equal(w.Code, http.StatusOK)
+
+	w = doRequest(t, s, http.MethodPut, "/"+id, []byte(`{"summaryId":"`+summaryId1+
+		`","matchedSummaryId":"`+summaryId2+`","matchRate":30}`))
+	is.Equal(w.Code, http.StatusOK)
+
+	w = doRequest(t, s, http.MethodPatch, "/"+id, []byte(`{"matchRate":45}`))
+	is.Equal(w.Code, http.StatusOK)
+	patched, err := store.GetMatching(context.Background(), matchingId)
+	is.NoErr(err)
+	is.Equal(patched.MatchRate, 45)
+	is.Equal(patched.SummaryId, makeObjectId(t, summaryId1))
+
+	w = doRequest(t, s, http.MethodDelete, "/"+id, nil)
+	is.Equal(w.Code, http.StatusNoContent)
+
+	w = doRequest(t, s, http.MethodGet, "/"+id, nil)
+	is.Equal(w.Code, http.StatusNotFound)
+	w = doRequest(t, s, http.MethodDelete, "/"+id, nil)
+	is.Equal(w.Code, http.StatusNotFound)
+}
+
+func TestServer_explainBreakdown(t *testing.T) {
+	is := iss.New(t)
+	s, store := newTestServer(t)
+	breakdown := `"breakdown":{"scorer":"weighted-overlap","version":"v1","components":[` +
+		`{"name":"skills","score":50,"weight":40},{"name":"location.city","score":0,"weight":20}]}`
+	w := doRequest(t, s, http.MethodPost, "/", []byte(`{"summaryId":"`+summaryId1+
+		`","matchedSummaryId":"`+summaryId2+`","matchRate":33,`+breakdown+`}`))
+	is.Equal(w.Code, http.StatusOK)
+	created := Matching{}
+	is.NoErr(json.NewDecoder(w.Body).Decode(&created))
+	id := created.Id.Hex()
+
+	readBreakdown := func(target string, list bool) *ScoreBreakdown {
+		w := doRequest(t, s, http.MethodGet, target, nil)
+		is.Equal(w.Code, http.StatusOK)
+		if list {
+			var matchings []Matching
+			is.NoErr(json.NewDecoder(w.Body).Decode(&matchings))
+			is.Equal(len(matchings), 1)
+			return matchings[0].Breakdown
+		}
+		matching := Matching{}
+		is.NoErr(json.NewDecoder(w.Body).Decode(&matching))
+		return matching.Breakdown
+	}
+	reads := []struct {
+		target, explain string
+		list            bool
+	}{
+		{target: "/" + id, explain: "/" + id + "?explain=true"},
+		{target: "/?summaryId=" + summaryId1, explain: "/?summaryId=" + summaryId1 + "&explain=true", list: true},
+		{target: "/summary/" + summaryId1, explain: "/summary/" + summaryId1 + "?explain=true", list: true},
+	}
+	for _, read := range reads {
+		is.Equal(readBreakdown(read.target, read.list), nil) // breakdown is returned on explain only
+		got := readBreakdown(read.explain, read.list)
+		is.True(got != nil)
+		is.Equal(got.Scorer, "weighted-overlap")
+		is.Equal(got.Components, []ScoreComponent{{Name: "skills", Score: 50, Weight: 40}, {Name: "location.city", Score: 0, Weight: 20}})
+	}
+	w = doRequest(t, s, http.MethodGet, "/"+id+"?explain=maybe", nil)
+	is.Equal(w.Code, http.StatusBadRequest)
+
+	// patched matchRate is no longer explained by the stored breakdown
+	w = doRequest(t, s, http.MethodPatch, "/"+id, []byte(`{"matchRate":45}`))
+	is.Equal(w.Code, http.StatusOK)
+	patched, err := store.GetMatching(context.Background(), created.Id)
+	is.NoErr(err)
+	is.Equal(patched.Breakdown, nil)
+
+	w = doRequest(t, s, http.MethodPut, "/"+id, []byte(`{"summaryId":"`+summaryId1+
+		`","matchedSummaryId":"`+summaryId2+`","matchRate":30,"breakdown":{"components":[{"name":"skills","score":120,"weight":1}]}}`))
+	is.Equal(w.Code, http.StatusUnprocessableEntity)
+	is.True(bytes.Contains(w.Body.Bytes(), []byte(`"breakdown.scorer"`)))
+	is.True(bytes.Contains(w.Body.Bytes(), []byte(`"breakdown.components[0].score"`)))
+}
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string
+	}{
+		{name: "replace value", doc: `{"a":1,"b":2}`, patch: `{"a":3}`, want: `{"a":3,"b":2}`},
+		{name: "remove value", doc: `{"a":1,"b":2}`, patch: `{"a":null}`, want: `{"b":2}`},
+		{name: "nested object", doc: `{"a":{"b":1,"c":2}}`, patch: `{"a":{"c":null,"d":3}}`, want: `{"a":{"b":1,"d":3}}`},
+		{name: "non object patch", doc: `{"a":1}`, patch: `[1]`, want: `[1]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := iss.New(t)
+			got, err := MergePatch([]byte(tt.doc), []byte(tt.patch))
+			is.NoErr(err)
+			is.Equal(string(got), tt.want)
+		})
+	}
+}
+
+func TestServer_getMatchingsHandler_paging(t *testing.T) {
+	is := iss.New(t)
+	s, store := newTestServer(t)
+	ctx := context.Background()
+	summary := makeObjectId(t, summaryId1)
+	for _, rate := range []int{10, 50, 50, 30, 90, 50, 70} {
+		_, err := store.CreateMatching(ctx, Matching{SummaryId: summary, MatchedSummaryId: primitive.NewObjectID(), MatchRate: rate})
+		is.NoErr(err)
+	}
+	_, err := store.CreateMatching(ctx, Matching{SummaryId: makeObjectId(t, summaryId2), MatchRate: 100})
+	is.NoErr(err)
+
+	gotRates := make([]int, 0)
+	seen := make(map[primitive.ObjectID]bool)
+	after := ""
+	for pages := 0; pages < 10; pages++ {
+		target := "/?summaryId=" + summaryId1 + "&minRate=20&sort=-matchRate&limit=2&after=" + after
+		w := doRequest(t, s, http.MethodGet, target, nil)
+		is.Equal(w.Code, http.StatusOK)
+		is.Equal(w.Header().Get(headerTotalCount), "6")
+
+		var matchings []Matching
+		is.NoErr(json.NewDecoder(w.Body).Decode(&matchings))
+		for _, m := range matchings {
+			is.True(!seen[m.Id])
+			seen[m.Id] = true
+			gotRates = append(gotRates, m.MatchRate)
+		}
+
+		after = w.Header().Get(headerNextCursor)
+		if after == "" {
+			break
+		}
+	}
+	is.Equal(gotRates, []int{90, 70, 50, 50, 50, 30})
+
+	w := doRequest(t, s, http.MethodGet, "/?sort=matchRate&after=bogus", nil)
+	is.Equal(w.Code, http.StatusBadRequest)
+	w = doRequest(t, s, http.MethodGet, "/?sort=name", nil)
+	is.Equal(w.Code, http.StatusBadRequest)
+}
+
+func TestServer_postMatchingHandler_validation(t *testing.T) {
+	is := iss.New(t)
+	s, store := newTestServer(t)
+
+	body := []byte(`{"summaryId":"` + summaryId1 + `","matchedSummaryId":"` + summaryId1 +
+		`","matchRate":-500,"score":1,"createdAt":"yesterday"}`)
+	w := doRequest(t, s, http.MethodPost, "/", body)
+	is.Equal(w.Code, http.StatusUnprocessableEntity)
+
+	var resp struct {
+		Error struct {
+			Message string       `json:"message"`
+			Fields  []FieldError `json:"fields"`
+		} `json:"error"`
+	}
+	is.NoErr(json.NewDecoder(w.Body).Decode(&resp))
+	is.Equal(resp.Error.Fields, []FieldError{
+		{Field: "createdAt", Message: "invalid value"},
+		{Field: "score", Message: "unknown field"},
+		{Field: "matchedSummaryId", Message: "must differ from summaryId"},
+		{Field: "matchRate", Message: "must be between 0 and 100"},
+	})
+
+	w = doRequest(t, s, http.MethodPost, "/", []byte(`{"matchRate":10}`))
+	is.Equal(w.Code, http.StatusUnprocessableEntity)
+
+	matchings, err := store.GetAllMatchings(context.Background())
+	is.NoErr(err)
+	is.Equal(len(matchings), 0)
+
+	w = doRequest(t, s, http.MethodPost, "/", []byte(`{"summaryId":"`+summaryId1+`","matchedSummaryId":"`+summaryId2+`","matchRate":10}`))
+	is.Equal(w.Code, http.StatusOK)
+	var created Matching
+	is.NoErr(json.NewDecoder(w.Body).Decode(&created))
+	is.True(created.Id != primitive.NilObjectID)
+	is.True(!created.CreatedAt.IsZero())
+}
+
+func TestServer_summaryChecks(t *testing.T) {
+	is := iss.New(t)
+	store := NewMemoryStore()
+	s := NewServer("test", store, WithSummaryChecks())
+	store.PutSummary(makeObjectId(t, summaryId1), SummaryDocument{})
+	store.PutSummary(makeObjectId(t, summaryId2), SummaryDocument{})
+	missing := primitive.NewObjectID().Hex()
+
+	w := doRequest(t, s, http.MethodPost, "/", []byte(`{"summaryId":"`+summaryId1+`","matchedSummaryId":"`+missing+`","matchRate":10}`))
+	is.Equal(w.Code, http.StatusBadRequest)
+
+	body := []byte(`[
+		{"summaryId":"` + summaryId1 + `","matchedSummaryId":"` + summaryId2 + `","matchRate":15},
+		{"summaryId":"` + missing + `","matchedSummaryId":"` + summaryId1 + `","matchRate":25}
+	]`)
+	w = doRequest(t, s, http.MethodPost, "/bulk", body)
+	is.Equal(w.Code, http.StatusOK)
+	var results []BulkItemResult
+	is.NoErr(json.NewDecoder(w.Body).Decode(&results))
+	is.Equal(results[0].Status, BulkStatusCreated)
+	is.Equal(results[1].Status, BulkStatusFailed)
+}
+
+func TestServer_verifyHandlers(t *testing.T) {
+	is := iss.New(t)
+	s, store := newTestServer(t)
+	store.PutSummary(makeObjectId(t, summaryId1), SummaryDocument{})
+	ctx := context.Background()
+	_, err := store.CreateMatching(ctx, Matching{SummaryId: makeObjectId(t, summaryId1), MatchedSummaryId: makeObjectId(t, summaryId2)})
+	is.NoErr(err)
+
+	w := doRequest(t, s, http.MethodGet, "/verify", nil)
+	is.Equal(w.Code, http.StatusOK)
+	var report VerifyReport
+	is.NoErr(json.NewDecoder(w.Body).Decode(&report))
+	is.Equal(report.Checked, int64(1))
+	is.Equal(len(report.Orphans), 1)
+	is.True(report.Orphans[0].MissingMatchedSummary)
+	is.True(!report.Orphans[0].MissingSummary)
+	is.Equal(report.Removed, int64(0))
+
+	w = doRequest(t, s, http.MethodPost, "/verify", nil)
+	is.Equal(w.Code, http.StatusOK)
+	is.NoErr(json.NewDecoder(w.Body).Decode(&report))
+	is.Equal(report.Removed, int64(1))
+
+	matchings, err := store.GetAllMatchings(ctx)
+	is.NoErr(err)
+	is.Equal(len(matchings), 0)
+}
+
+func TestServer_eraseHandlers(t *testing.T) {
+	is := iss.New(t)
+	s, store := newTestServer(t)
+	ctx := context.Background()
+	profileId := primitive.NewObjectID()
+	otherId := primitive.NewObjectID()
+	store.PutSummary(makeObjectId(t, summaryId1), SummaryDocument{"profileId": profileId})
+	store.PutSummary(makeObjectId(t, summaryId2), SummaryDocument{"profileId": profileId})
+	store.PutSummary(otherId, SummaryDocument{})
+	pairs := [][2]primitive.ObjectID{
+		{makeObjectId(t, summaryId1), otherId},
+		{otherId, makeObjectId(t, summaryId1)},
+		{makeObjectId(t, summaryId2), otherId},
+	}
+	for _, pair := range pairs {
+		_, err := store.CreateMatching(ctx, Matching{SummaryId: pair[0], MatchedSummaryId: pair[1], MatchRate: 10})
+		is.NoErr(err)
+	}
+
+	w := doRequest(t, s, http.MethodDelete, "/summary/"+summaryId1, nil)
+	is.Equal(w.Code, http.StatusOK)
+	var audit ErasureAudit
+	is.NoErr(json.NewDecoder(w.Body).Decode(&audit))
+	is.Equal(audit.Scope, ErasureScopeSummary)
+	is.Equal(audit.DeletedMatchings, int64(2))
+	is.True(audit.Id != primitive.NilObjectID)
+
+	w = doRequest(t, s, http.MethodDelete, "/profile/"+profileId.Hex(), nil)
+	is.Equal(w.Code, http.StatusOK)
+	is.NoErr(json.NewDecoder(w.Body).Decode(&audit))
+	is.Equal(audit.Scope, ErasureScopeProfile)
+	is.Equal(audit.SubjectId, profileId)
+	is.Equal(len(audit.SummaryIds), 2)
+	is.Equal(audit.DeletedMatchings, int64(1))
+
+	matchings, err := store.GetAllMatchings(ctx)
+	is.NoErr(err)
+	is.Equal(len(matchings), 0)
+	is.Equal(len(store.audits), 2)
+	is.Equal(store.audits[0].Status, ErasureStatusDone)
+
+	w = doRequest(t, s, http.MethodDelete, "/profile/bad", nil)
+	is.Equal(w.Code, http.StatusBadRequest)
+}
+
+// failingDeleteStore fails every delete of matchings.
+type failingDeleteStore struct {
+	*MemoryStore
+}
+
+func (s failingDeleteStore) DeleteMatchingsBySummaries(ctx context.Context, summaryIDs []primitive.ObjectID) (int64, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestServer_eraseHandlers_failure(t *testing.T) {
+	is := iss.New(t)
+	store := NewMemoryStore()
+	s := NewServer("test", failingDeleteStore{store})
+
+	w := doRequest(t, s, http.MethodDelete, "/summary/"+summaryId1, nil)
+	is.Equal(w.Code, http.StatusServiceUnavailable)
+
+	// failed erasure is recorded
+	is.Equal(len(store.audits), 1)
+	is.Equal(store.audits[0].Status, ErasureStatusFailed)
+	is.Equal(store.audits[0].Error, "erase default: connection reset")
+	is.Equal(store.audits[0].SummaryIds, []primitive.ObjectID{makeObjectId(t, summaryId1)})
+}
+
+type unreachableStore struct {
+	*MemoryStore
+}
+
+func (s unreachableStore) Ping(ctx context.Context) error {
+	return errors.New("server selection timeout")
+}
+
+func TestServer_healthHandlers(t *testing.T) {
+	is := iss.New(t)
+	s, _ := newTestServer(t)
+
+	w := httptest.NewRecorder()
+	s.healthzHandler(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	is.Equal(w.Code, http.StatusOK)
+
+	w = httptest.NewRecorder()
+	s.readyzHandler(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	is.Equal(w.Code, http.StatusOK)
+	var report HealthReport
+	is.NoErr(json.NewDecoder(w.Body).Decode(&report))
+	is.Equal(report.Status, HealthStatusOK)
+	is.Equal(report.Checks["database"].Status, HealthStatusOK)
+	is.Equal(report.Checks["indexes"].Status, HealthStatusOK)
+
+	s = NewServer("test", unreachableStore{NewMemoryStore()})
+	w = httptest.NewRecorder()
+	s.readyzHandler(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	is.Equal(w.Code, http.StatusServiceUnavailable)
+	is.NoErr(json.NewDecoder(w.Body).Decode(&report))
+	is.Equal(report.Status, HealthStatusUnavailable)
+	is.Equal(report.Checks["database"].Error, "server selection timeout")
+}
+
+func TestServer_rateLimit(t *testing.T) {
+	is := iss.New(t)
+	s := NewServer("test", NewMemoryStore(), WithRateLimits(RateLimits{Read: 2, Write: 1}))
+	get := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		s.Router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("10.0.0.1:1234")
+	is.Equal(w.Code, http.StatusOK)
+	is.Equal(w.Header().Get(headerRateLimitLimit), "2")
+	is.Equal(w.Header().Get(headerRateLimitRemaining), "1")
+	is.Equal(get("10.0.0.1:1235").Code, http.StatusOK)
+
+	w = get("10.0.0.1:1236")
+	is.Equal(w.Code, http.StatusTooManyRequests)
+	is.Equal(w.Header().Get(headerRetryAfter), "30")
+	is.Equal(w.Header().Get(headerRateLimitRemaining), "0")
+	var body map[string]map[string]string
+	is.NoErr(json.NewDecoder(w.Body).Decode(&body))
+	is.True(body["error"]["message"] != "")
+
+	is.Equal(get("10.0.0.2:1234").Code, http.StatusOK) // other client
+
+	// write group has its own bucket
+	req := httptest.NewRequest(http.MethodDelete, "/"+primitive.NewObjectID().Hex(), nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	w = httptest.NewRecorder()
+	s.Router.ServeHTTP(w, req)
+	is.Equal(w.Code, http.StatusNotFound)
+}
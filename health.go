@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// readinessTimeout is the deadline of all dependency checks of the readiness probe.
+const readinessTimeout = 2 * time.Second
+
+// Health check statuses.
+const (
+	HealthStatusOK          = "ok"
+	HealthStatusUnavailable = "unavailable"
+)
+
+// HealthStore reports health of the storage backend.
+type HealthStore interface {
+	Ping(ctx context.Context) error
+	// MissingIndexes returns names of required indexes which do not exist.
+	MissingIndexes(ctx context.Context) ([]string, error)
+	// CountMatchings returns estimated number of stored matchings.
+	CountMatchings(ctx context.Context) (int64, error)
+}
+
+// HealthCheck is the result of a single dependency check.
+type HealthCheck struct {
+	Status  string             `json:"status"`
+	Latency string             `json:"latency,omitempty"`
+	Error   string             `json:"error,omitempty"`
+	Job     *RecomputeProgress `json:"job,omitempty"`
+}
+
+// HealthReport is the response of health endpoints, Status is ok only when all checks are ok.
+type HealthReport struct {
+	Status string                 `json:"status"`
+	Build  string                 `json:"build,omitempty"`
+	Checks map[string]HealthCheck `json:"checks,omitempty"`
+}
+
+// healthzHandler reports that the process is alive.
+// endpoint: GET /healthz
+func (s *Server) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	Respond(w, r, http.StatusOK, HealthReport{Status: HealthStatusOK, Build: s.build})
+}
+
+// readyzHandler reports whether the service can serve requests, database has to be reachable
+// and required indexes have to exist. Background job state is reported but never fails the probe.
+// endpoint: GET /readyz
+func (s *Server) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	report := HealthReport{Status: HealthStatusOK, Build: s.build, Checks: make(map[string]HealthCheck)}
+	report.Checks["database"] = checkHealth(func() error {
+		return s.store.Ping(ctx)
+	})
+	report.Checks["indexes"] = checkHealth(func() error {
+		missing, err := s.store.MissingIndexes(ctx)
+		if err != nil {
+			return err
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("missing indexes %v", missing)
+		}
+		return nil
+	})
+	if s.recompute != nil {
+		progress := s.recompute.Progress()
+		report.Checks["recomputeJob"] = HealthCheck{Status: HealthStatusOK, Job: &progress}
+	}
+
+	status := http.StatusOK
+	for _, check := range report.Checks {
+		if check.Status != HealthStatusOK {
+			report.Status = HealthStatusUnavailable
+			status = http.StatusServiceUnavailable
+		}
+	}
+	Respond(w, r, status, report)
+}
+
+// checkHealth runs the check and measures its latency.
+func checkHealth(check func() error) HealthCheck {
+	start := time.Now()
+	err := check()
+	result := HealthCheck{Status: HealthStatusOK, Latency: time.Since(start).String()}
+	if err != nil {
+		result.Status = HealthStatusUnavailable
+		result.Error = err.Error()
+	}
+	return result
+}
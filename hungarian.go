@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Limits of the optimal assignment, it takes O(n²m) time for n rows and m columns of the smaller
+// and the larger side, so it accepts much smaller requests than the stable assignment.
+const (
+	// maxOptimalAssignmentMembers limits the number of summaries on each side.
+	maxOptimalAssignmentMembers = 200
+	// maxOptimalAssignmentCells limits proposers times acceptor columns, an acceptor with
+	// capacity is a column repeated capacity times.
+	maxOptimalAssignmentCells = 40000
+)
+
+// OptimalAssignment pairs proposers with acceptors maximizing the total matchRate of both
+// directions by the Hungarian (Kuhn–Munkres) algorithm. Pairs not matched in both directions
+// with at least MinRate are never assigned. Acceptor with capacity takes up to that many proposers.
+// Members are ordered by id before solving, so equally good assignments are resolved the same
+// way for any order of the request. It returns *ValidationError for invalid or too large request.
+func OptimalAssignment(ctx context.Context, store MatchingStore, req AssignmentRequest) (Assignment, error) {
+	capacities, err := req.validate()
+	if err != nil {
+		return Assignment{}, err
+	}
+	if err := validateOptimalSize(req, capacities); err != nil {
+		return Assignment{}, err
+	}
+	rates, err := loadAssignmentRates(ctx, store, req)
+	if err != nil {
+		return Assignment{}, err
+	}
+	return optimalAssignment(ctx, req.Proposers, req.Acceptors, capacities, rates, req.MinRate)
+}
+
+// validateOptimalSize checks the request is small enough to be solved by the Hungarian algorithm.
+func validateOptimalSize(req AssignmentRequest, capacities map[primitive.ObjectID]int) error {
+	verr := &ValidationError{}
+	tooMany := fmt.Sprintf("too many summaries, max %d for optimal assignment", maxOptimalAssignmentMembers)
+	if len(req.Proposers) > maxOptimalAssignmentMembers {
+		verr.add("proposers", tooMany)
+	}
+	if len(req.Acceptors) > maxOptimalAssignmentMembers {
+		verr.add("acceptors", tooMany)
+	}
+
+	columns := 0
+	for _, c := range capacities {
+		if c > len(req.Proposers) {
+			c = len(req.Proposers)
+		}
+		columns += c
+	}
+	if len(req.Proposers)*columns > maxOptimalAssignmentCells {
+		verr.add("capacities", fmt.Sprintf("proposers times acceptor capacities exceed %d", maxOptimalAssignmentCells))
+	}
+	return verr.orNil()
+}
+
+func optimalAssignment(ctx context.Context, proposers, acceptors []primitive.ObjectID, capacities map[primitive.ObjectID]int, rates pairRates, minRate int) (Assignment, error) {
+	rows := sortedObjectIDs(proposers)
+	// acceptor with capacity is a column repeated capacity times, more than rows are never used
+	columns := make([]primitive.ObjectID, 0, len(acceptors))
+	for _, a := range sortedObjectIDs(acceptors) {
+		for i := 0; i < capacities[a] && i < len(rows); i++ {
+			columns = append(columns, a)
+		}
+	}
+
+	// Rows and columns left without an allowed pair are padded with forbidden edges of zero weight.
+	// Weight of allowed pair is scaled, so the total rate is maximized first and the number of
+	// pairs second, zero rated pair is still preferred to no pair.
+	scale := int64(len(rows) + 1)
+	weights := make([][]int64, len(rows))
+	for i, p := range rows {
+		weights[i] = make([]int64, len(columns))
+		for j, a := range columns {
+			if rate, reverse, ok := rates.mutual(p, a, minRate); ok {
+				weights[i][j] = int64(rate+reverse)*scale + 1
+			}
+		}
+	}
+
+	assigned, err := maxWeightAssignment(ctx, weights, len(columns))
+	if err != nil {
+		return Assignment{}, err
+	}
+	assignedTo := make(map[primitive.ObjectID]primitive.ObjectID, len(rows))
+	for i, j := range assigned {
+		if j >= 0 && weights[i][j] > 0 {
+			assignedTo[rows[i]] = columns[j]
+		}
+	}
+	return newAssignment(proposers, acceptors, assignedTo, rates), nil
+}
+
+// maxWeightAssignment returns column assigned to each row of the weights matrix with columns
+// columns, so the sum of weights is maximal. Row is -1 when it has no column.
+// It returns ctx error when ctx is done before the assignment is solved.
+func maxWeightAssignment(ctx context.Context, weights [][]int64, columns int) ([]int, error) {
+	rows := len(weights)
+	result := make([]int, rows)
+	for i := range result {
+		result[i] = -1
+	}
+	if rows == 0 || columns == 0 {
+		return result, nil
+	}
+
+	// hungarian requires rows not exceeding columns, transpose wide side to columns
+	if rows > columns {
+		transposed := make([][]int64, columns)
+		for j := range transposed {
+			transposed[j] = make([]int64, rows)
+			for i := range weights {
+				transposed[j][i] = weights[i][j]
+			}
+		}
+		assigned, err := hungarian(ctx, transposed)
+		if err != nil {
+			return nil, err
+		}
+		for j, i := range assigned {
+			result[i] = j
+		}
+		return result, nil
+	}
+	return hungarian(ctx, weights)
+}
+
+// hungarian solves the assignment of n rows to m >= n columns maximizing the sum of weights
+// with potentials in O(n²m). It returns the column of each row, ctx is checked before each row.
+func hungarian(ctx context.Context, weights [][]int64) ([]int, error) {
+	n, m := len(weights), len(weights[0])
+	// 1-based potentials u of rows and v of columns, p[j] is the row of column j, 0 means none
+	u := make([]int64, n+1)
+	v := make([]int64, m+1)
+	p := make([]int, m+1)
+	way := make([]int, m+1)
+	minv := make([]int64, m+1)
+	used := make([]bool, m+1)
+	for i := 1; i <= n; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		p[0] = i
+		j0 := 0
+		for j := range minv {
+			minv[j] = math.MaxInt64
+			used[j] = false
+		}
+		for {
+			used[j0] = true
+			i0, delta, j1 := p[j0], int64(math.MaxInt64), 0
+			for j := 1; j <= m; j++ {
+				if used[j] {
+					continue
+				}
+				// cost is negative weight, the algorithm minimizes cost
+				if cur := -weights[i0-1][j-1] - u[i0] - v[j]; cur < minv[j] {
+					minv[j] = cur
+					way[j] = j0
+				}
+				if minv[j] < delta {
+					delta = minv[j]
+					j1 = j
+				}
+			}
+			for j := 0; j <= m; j++ {
+				if used[j] {
+					u[p[j]] += delta
+					v[j] -= delta
+				} else {
+					minv[j] -= delta
+				}
+			}
+			j0 = j1
+			if p[j0] == 0 {
+				break
+			}
+		}
+		for j0 != 0 {
+			j1 := way[j0]
+			p[j0] = p[j1]
+			j0 = j1
+		}
+	}
+
+	result := make([]int, n)
+	for j := 1; j <= m; j++ {
+		if p[j] != 0 {
+			result[p[j]-1] = j - 1
+		}
+	}
+	return result, nil
+}
+
+func sortedObjectIDs(ids []primitive.ObjectID) []primitive.ObjectID {
+	sorted := append([]primitive.ObjectID(nil), ids...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i][:], sorted[j][:]) < 0
+	})
+	return sorted
+}
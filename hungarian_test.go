@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"testing"
+
+	iss "github.com/matryer/is"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// bruteForceMaxWeight returns the max sum of weights of rows assigned to distinct columns.
+func bruteForceMaxWeight(weights [][]int64, row int, used []bool) int64 {
+	if row == len(weights) {
+		return 0
+	}
+	best := bruteForceMaxWeight(weights, row+1, used)
+	for j := range used {
+		if used[j] {
+			continue
+		}
+		used[j] = true
+		if total := weights[row][j] + bruteForceMaxWeight(weights, row+1, used); total > best {
+			best = total
+		}
+		used[j] = false
+	}
+	return best
+}
+
+func TestMaxWeightAssignment(t *testing.T) {
+	is := iss.New(t)
+	random := rand.New(rand.NewSource(1))
+	for _, size := range [][2]int{{1, 1}, {3, 3}, {4, 6}, {6, 4}, {5, 5}, {2, 7}} {
+		for n := 0; n < 20; n++ {
+			weights := make([][]int64, size[0])
+			for i := range weights {
+				weights[i] = make([]int64, size[1])
+				for j := range weights[i] {
+					// about a third of edges is forbidden
+					if random.Intn(3) > 0 {
+						weights[i][j] = random.Int63n(10)
+					}
+				}
+			}
+
+			columns, err := maxWeightAssignment(context.Background(), weights, size[1])
+			is.NoErr(err)
+			taken := make(map[int]bool)
+			total := int64(0)
+			for i, j := range columns {
+				if j < 0 {
+					continue
+				}
+				is.True(!taken[j]) // column assigned twice
+				taken[j] = true
+				total += weights[i][j]
+			}
+			is.Equal(total, bruteForceMaxWeight(weights, 0, make([]bool, size[1])))
+		}
+	}
+}
+
+func TestServer_postOptimalAssignmentHandler(t *testing.T) {
+	s, store := newTestServer(t)
+	ids := make(map[string]primitive.ObjectID)
+	for _, name := range []string{"p1", "p2", "p3", "a1", "a2"} {
+		ids[name] = primitive.NewObjectID()
+	}
+	// p2-a2 is not matched, so the best total gives up the best pair p1-a1
+	storeRates(t, store, ids, [][3]interface{}{
+		{"p1", "a1", 100}, {"a1", "p1", 100},
+		{"p1", "a2", 90}, {"a2", "p1", 90},
+		{"p2", "a1", 90}, {"a1", "p2", 90},
+		{"p3", "a1", 50}, {"a1", "p3", 40},
+		{"p3", "a2", 45}, {"a2", "p3", 45},
+	})
+	pair := func(p, a string) [2]primitive.ObjectID { return [2]primitive.ObjectID{ids[p], ids[a]} }
+
+	tests := []struct {
+		name          string
+		req           AssignmentRequest
+		wantPairs     [][2]primitive.ObjectID
+		wantTotal     int
+		wantUnmatched []primitive.ObjectID
+	}{
+		{
+			name:          "max total",
+			req:           AssignmentRequest{Proposers: []primitive.ObjectID{ids["p1"], ids["p2"]}, Acceptors: []primitive.ObjectID{ids["a1"], ids["a2"]}},
+			wantPairs:     [][2]primitive.ObjectID{pair("p1", "a2"), pair("p2", "a1")},
+			wantTotal:     360,
+			wantUnmatched: []primitive.ObjectID{},
+		},
+		{
+			name:          "more proposers than acceptors",
+			req:           AssignmentRequest{Proposers: []primitive.ObjectID{ids["p3"], ids["p2"], ids["p1"]}, Acceptors: []primitive.ObjectID{ids["a2"], ids["a1"]}},
+			wantPairs:     [][2]primitive.ObjectID{pair("p2", "a1"), pair("p1", "a2")},
+			wantTotal:     360,
+			wantUnmatched: []primitive.ObjectID{ids["p3"]},
+		},
+		{
+			name: "capacity",
+			req: AssignmentRequest{Proposers: []primitive.ObjectID{ids["p1"], ids["p2"], ids["p3"]}, Acceptors: []primitive.ObjectID{ids["a1"], ids["a2"]},
+				Capacities: map[string]int{ids["a1"].Hex(): 2}},
+			wantPairs:     [][2]primitive.ObjectID{pair("p1", "a1"), pair("p2", "a1"), pair("p3", "a2")},
+			wantTotal:     470,
+			wantUnmatched: []primitive.ObjectID{},
+		},
+		{
+			name:          "min rate",
+			req:           AssignmentRequest{Proposers: []primitive.ObjectID{ids["p2"], ids["p3"]}, Acceptors: []primitive.ObjectID{ids["a1"], ids["a2"]}, MinRate: 95},
+			wantPairs:     [][2]primitive.ObjectID{},
+			wantUnmatched: []primitive.ObjectID{ids["p2"], ids["p3"]},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := iss.New(t)
+			code, assignment := postAssignment(t, s, "optimal", tt.req)
+			is.Equal(code, http.StatusOK)
+
+			gotPairs := make([][2]primitive.ObjectID, 0)
+			for _, p := range assignment.Pairs {
+				gotPairs = append(gotPairs, [2]primitive.ObjectID{p.ProposerId, p.AcceptorId})
+			}
+			is.Equal(gotPairs, tt.wantPairs)
+			is.Equal(assignment.Total, tt.wantTotal)
+			is.Equal(assignment.UnmatchedProposers, tt.wantUnmatched)
+		})
+	}
+
+	code, _ := postAssignment(t, s, "optimal", AssignmentRequest{Proposers: []primitive.ObjectID{ids["p1"]}})
+	iss.New(t).Equal(code, http.StatusUnprocessableEntity)
+}
+
+func TestOptimalAssignment_limits(t *testing.T) {
+	newIDs := func(n int) []primitive.ObjectID {
+		result := make([]primitive.ObjectID, n)
+		for i := range result {
+			result[i] = primitive.NewObjectID()
+		}
+		return result
+	}
+	tests := []struct {
+		name string
+		req  AssignmentRequest
+	}{
+		{name: "too many proposers", req: AssignmentRequest{Proposers: newIDs(maxOptimalAssignmentMembers + 1), Acceptors: newIDs(1)}},
+		{name: "too many acceptors", req: AssignmentRequest{Proposers: newIDs(1), Acceptors: newIDs(maxOptimalAssignmentMembers + 1)}},
+		{name: "too many columns", req: AssignmentRequest{Proposers: newIDs(maxOptimalAssignmentMembers), Acceptors: newIDs(maxOptimalAssignmentMembers), Capacity: 2}},
+		{name: "capacity", req: AssignmentRequest{Proposers: newIDs(1), Acceptors: newIDs(1), Capacity: maxAssignmentMembers + 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := iss.New(t)
+			_, err := OptimalAssignment(context.Background(), NewMemoryStore(), tt.req)
+			var verr *ValidationError
+			is.True(errors.As(err, &verr))
+		})
+	}
+
+	is := iss.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := maxWeightAssignment(ctx, [][]int64{{1, 2}, {3, 4}}, 2)
+	is.Equal(err, context.Canceled)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// OrphanMatching is a matching referencing summary which does not exist.
+type OrphanMatching struct {
+	Id                    primitive.ObjectID `json:"id" bson:"_id"`
+	SummaryId             primitive.ObjectID `json:"summaryId" bson:"summaryId"`
+	MatchedSummaryId      primitive.ObjectID `json:"matchedSummaryId" bson:"matchedSummaryId"`
+	MissingSummary        bool               `json:"missingSummary" bson:"missingSummary"`
+	MissingMatchedSummary bool               `json:"missingMatchedSummary" bson:"missingMatchedSummary"`
+}
+
+// VerifyReport is the result of the matching collection integrity check.
+type VerifyReport struct {
+	Checked int64            `json:"checked"`
+	Orphans []OrphanMatching `json:"orphans"`
+	Removed int64            `json:"removed"`
+}
+
+// IntegrityStore checks references of stored matchings.
+type IntegrityStore interface {
+	// VerifyMatchings finds matchings referencing missing summaries and removes them when remove is true.
+	VerifyMatchings(ctx context.Context, remove bool) (VerifyReport, error)
+}
+
+// integrityStore is MatchingStore which writes matching only when both of its summaries exist.
+type integrityStore struct {
+	MatchingStore
+	summaries SummaryStore
+}
+
+// NewIntegrityStore wraps passed store with summary existence checks on write.
+// Writes referencing missing summaries fail with ErrInvalid.
+func NewIntegrityStore(store MatchingStore, summaries SummaryStore) MatchingStore {
+	return &integrityStore{MatchingStore: store, summaries: summaries}
+}
+
+func (s *integrityStore) CreateMatching(ctx context.Context, matching Matching) (primitive.ObjectID, error) {
+	if err := s.check(ctx, matching); err != nil {
+		return primitive.NilObjectID, err
+	}
+	return s.MatchingStore.CreateMatching(ctx, matching)
+}
+
+func (s *integrityStore) UpdateMatching(ctx context.Context, matching Matching) (int64, error) {
+	if err := s.check(ctx, matching); err != nil {
+		return 0, err
+	}
+	return s.MatchingStore.UpdateMatching(ctx, matching)
+}
+
+func (s *integrityStore) BulkWriteMatchings(ctx context.Context, matchings []Matching) ([]BulkItemResult, error) {
+	return s.bulkWrite(ctx, matchings, s.MatchingStore.BulkWriteMatchings)
+}
+
+func (s *integrityStore) UpsertMatchingsByPair(ctx context.Context, matchings []Matching) ([]BulkItemResult, error) {
+	return s.bulkWrite(ctx, matchings, s.MatchingStore.UpsertMatchingsByPair)
+}
+
+// bulkWrite writes matchings of existing summaries with passed write function,
+// the rest are reported as failed.
+func (s *integrityStore) bulkWrite(ctx context.Context, matchings []Matching,
+	write func(context.Context, []Matching) ([]BulkItemResult, error)) ([]BulkItemResult, error) {
+
+	existing, err := s.summaries.ExistingSummaryIds(ctx, summaryIdsOf(matchings))
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]BulkItemResult, len(matchings))
+	valid := make([]Matching, 0, len(matchings))
+	indexes := make([]int, 0, len(matchings))
+	for i, matching := range matchings {
+		if err := missingSummaryError(matching, existing); err != nil {
+			results[i] = BulkItemResult{Index: i, Id: matching.Id, Status: BulkStatusFailed, Error: err.Error()}
+			continue
+		}
+		valid = append(valid, matching)
+		indexes = append(indexes, i)
+	}
+
+	written, err := write(ctx, valid)
+	if err != nil {
+		return nil, err
+	}
+	for i, result := range written {
+		result.Index = indexes[i]
+		results[result.Index] = result
+	}
+	return results, nil
+}
+
+func (s *integrityStore) check(ctx context.Context, matching Matching) error {
+	existing, err := s.summaries.ExistingSummaryIds(ctx, summaryIdsOf([]Matching{matching}))
+	if err != nil {
+		return err
+	}
+	return missingSummaryError(matching, existing)
+}
+
+func summaryIdsOf(matchings []Matching) []primitive.ObjectID {
+	seen := make(map[primitive.ObjectID]bool)
+	ids := make([]primitive.ObjectID, 0)
+	for _, matching := range matchings {
+		for _, id := range []primitive.ObjectID{matching.SummaryId, matching.MatchedSummaryId} {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+func missingSummaryError(matching Matching, existing map[primitive.ObjectID]bool) error {
+	if !existing[matching.SummaryId] {
+		return fmt.Errorf("%w: summary %s does not exist", ErrInvalid, matching.SummaryId.Hex())
+	}
+	if !existing[matching.MatchedSummaryId] {
+		return fmt.Errorf("%w: matched summary %s does not exist", ErrInvalid, matching.MatchedSummaryId.Hex())
+	}
+	return nil
+}
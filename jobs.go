@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// recomputeJobName is the checkpoint key of the full recompute job.
+const recomputeJobName = "recompute"
+
+// defaultRecomputeBatchSize is the number of matchings upserted with a single bulk write.
+const defaultRecomputeBatchSize = 500
+
+// defaultRecomputePageSize is the number of summaries read at once.
+const defaultRecomputePageSize = 1000
+
+// Background job states.
+const (
+	JobStateIdle      = "idle"
+	JobStateRunning   = "running"
+	JobStateDone      = "done"
+	JobStateCancelled = "cancelled"
+	JobStateFailed    = "failed"
+)
+
+// JobCheckpoint is the persisted position of a background job.
+// All summaries up to and including LastSummaryId were fully processed.
+type JobCheckpoint struct {
+	Job           string             `json:"job" bson:"_id"`
+	LastSummaryId primitive.ObjectID `json:"lastSummaryId" bson:"lastSummaryId"`
+	UpdatedAt     time.Time          `json:"updatedAt" bson:"updatedAt"`
+}
+
+// RecomputeProgress is a snapshot of the recompute job progress.
+type RecomputeProgress struct {
+	State          string    `json:"state"`
+	SummariesTotal int64     `json:"summariesTotal"`
+	SummariesDone  int64     `json:"summariesDone"`
+	PairsScored    int64     `json:"pairsScored"`
+	PairsWritten   int64     `json:"pairsWritten"`
+	Errors         int64     `json:"errors"`
+	LastError      string    `json:"lastError,omitempty"`
+	StartedAt      time.Time `json:"startedAt,omitempty"`
+	FinishedAt     time.Time `json:"finishedAt,omitempty"`
+}
+
+// RecomputeJob scores every ordered pair of summaries with a bounded pool of workers
+// and upserts resulting matchings. Summaries are read by pages ordered by _id, each worker
+// scores one summary against a page of summaries at a time. Only one run of the job can be active at a time.
+type RecomputeJob struct {
+	engine    *ScoringEngine
+	store     DataStore
+	workers   int
+	batchSize int
+	pageSize  int
+
+	mu       sync.Mutex
+	progress RecomputeProgress
+	err      error
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// NewRecomputeJob creates recompute job with passed number of workers, number of CPUs is used when workers is not positive.
+func NewRecomputeJob(engine *ScoringEngine, store DataStore, workers int) *RecomputeJob {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	return &RecomputeJob{
+		engine:    engine,
+		store:     store,
+		workers:   workers,
+		batchSize: defaultRecomputeBatchSize,
+		pageSize:  defaultRecomputePageSize,
+		progress:  RecomputeProgress{State: JobStateIdle},
+	}
+}
+
+// Start runs the job in background. When resume is true, summaries processed
+// by the previous interrupted run are skipped. It returns ErrConflict when job is already running.
+func (j *RecomputeJob) Start(ctx context.Context, resume bool) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.progress.State == JobStateRunning {
+		return fmt.Errorf("%w: recompute job is already running", ErrConflict)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	j.cancel = cancel
+	j.done = done
+	j.err = nil
+	j.progress = RecomputeProgress{State: JobStateRunning, StartedAt: time.Now().UTC()}
+
+	go func() {
+		defer close(done)
+		defer cancel()
+		j.finish(j.run(ctx, resume))
+	}()
+	return nil
+}
+
+// Run runs the job and waits until it is finished.
+func (j *RecomputeJob) Run(ctx context.Context, resume bool) error {
+	if err := j.Start(ctx, resume); err != nil {
+		return err
+	}
+	return j.Wait()
+}
+
+// Cancel stops running job, progress is kept in the checkpoint.
+func (j *RecomputeJob) Cancel() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.cancel != nil {
+		j.cancel()
+	}
+}
+
+// Wait blocks until running job is finished and returns its error.
+func (j *RecomputeJob) Wait() error {
+	j.mu.Lock()
+	done := j.done
+	j.mu.Unlock()
+	if done != nil {
+		<-done
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.err
+}
+
+// Shutdown cancels running job and waits until it is finished or ctx is done.
+func (j *RecomputeJob) Shutdown(ctx context.Context) error {
+	j.Cancel()
+	finished := make(chan struct{})
+	go func() {
+		j.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Progress returns a snapshot of the job progress.
+func (j *RecomputeJob) Progress() RecomputeProgress {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.progress
+}
+
+func (j *RecomputeJob) finish(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.err = err
+	j.progress.FinishedAt = time.Now().UTC()
+	switch {
+	case err == nil:
+		j.progress.State = JobStateDone
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		j.progress.State = JobStateCancelled
+	default:
+		j.progress.State = JobStateFailed
+		j.progress.LastError = err.Error()
+	}
+}
+
+func (j *RecomputeJob) run(ctx context.Context, resume bool) error {
+	after, err := j.resumeAfter(ctx, resume)
+	if err != nil {
+		return err
+	}
+	total, err := j.store.CountSummariesAfter(ctx, primitive.NilObjectID)
+	if err != nil {
+		return err
+	}
+	remaining, err := j.store.CountSummariesAfter(ctx, after)
+	if err != nil {
+		return err
+	}
+	j.update(func(p *RecomputeProgress) {
+		p.SummariesTotal = total
+		p.SummariesDone = total - remaining
+	})
+
+	// checkpoint is advanced only over a contiguous range of completed summaries,
+	// so resumed run never skips a summary which was not fully processed.
+	var notCompleted int
+	for {
+		sources, err := j.store.GetSummariesAfter(ctx, after, j.pageSize)
+		if err != nil {
+			return err
+		}
+		if len(sources) == 0 {
+			break
+		}
+
+		failed, err := j.scorePage(ctx, sources)
+		if err != nil {
+			return err
+		}
+		var checkpoint primitive.ObjectID
+		for i, summary := range sources {
+			if failed[i] > 0 {
+				notCompleted++
+				continue
+			}
+			j.update(func(p *RecomputeProgress) { p.SummariesDone++ })
+			if notCompleted == 0 {
+				checkpoint = summary.ID()
+			}
+		}
+		if checkpoint != primitive.NilObjectID {
+			j.saveCheckpoint(checkpoint)
+		}
+		after = sources[len(sources)-1].ID()
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// checkpoint is kept before the first failed summary, so resumed run retries it
+	if notCompleted > 0 {
+		return fmt.Errorf("%d summaries were not fully scored and written, resume to retry", notCompleted)
+	}
+
+	checkpointCtx, cancel := AddTimeoutContext(context.Background())
+	defer cancel()
+	return j.store.DeleteCheckpoint(checkpointCtx, recomputeJobName)
+}
+
+// resumeAfter returns id of the last summary processed by previous run, or primitive.NilObjectID
+// when job starts with the first summary.
+func (j *RecomputeJob) resumeAfter(ctx context.Context, resume bool) (primitive.ObjectID, error) {
+	if !resume {
+		return primitive.NilObjectID, nil
+	}
+
+	checkpoint, err := j.store.GetCheckpoint(ctx, recomputeJobName)
+	if errors.Is(err, ErrNotFound) {
+		return primitive.NilObjectID, nil
+	}
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return checkpoint.LastSummaryId, nil
+}
+
+// scorePage scores every summary of sources against all summaries, which are read page by page,
+// so only two pages are held in memory. Workers score one source against the page at a time.
+// It returns the number of pairs of each source which failed to be scored or written.
+func (j *RecomputeJob) scorePage(ctx context.Context, sources []SummaryDocument) ([]int64, error) {
+	failed := make([]int64, len(sources))
+	after := primitive.NilObjectID
+	for {
+		targets, err := j.store.GetSummariesAfter(ctx, after, j.pageSize)
+		if err != nil {
+			return nil, err
+		}
+		if len(targets) == 0 {
+			return failed, nil
+		}
+
+		indexes := make(chan int)
+		go func() {
+			defer close(indexes)
+			for i := range sources {
+				select {
+				case indexes <- i:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+
+		var wg sync.WaitGroup
+		for w := 0; w < j.workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range indexes {
+					// each source is scored by a single worker per page
+					failed[i] += j.scoreSummary(ctx, sources[i], targets)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		after = targets[len(targets)-1].ID()
+	}
+}
+
+// scoreSummary scores summary against targets except itself and upserts results in batches.
+// It returns the number of pairs which failed to be scored or written.
+func (j *RecomputeJob) scoreSummary(ctx context.Context, summary SummaryDocument, targets []SummaryDocument) int64 {
+	batch := make([]Matching, 0, j.batchSize)
+	var failed int64
+	for _, matchedSummary := range targets {
+		if ctx.Err() != nil {
+			return failed
+		}
+		if matchedSummary.ID() == summary.ID() {
+			continue
+		}
+
+		matching, err := j.engine.ScoreDocuments(summary.ID(), matchedSummary.ID(), summary, matchedSummary)
+		if err != nil {
+			j.recordErrors(1, err)
+			failed++
+			continue
+		}
+
+		batch = append(batch, matching)
+		if len(batch) == j.batchSize {
+			failed += j.write(ctx, batch)
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		failed += j.write(ctx, batch)
+	}
+	return failed
+}
+
+// write upserts the batch and returns the number of matchings which were not written.
+func (j *RecomputeJob) write(ctx context.Context, batch []Matching) int64 {
+	j.update(func(p *RecomputeProgress) { p.PairsScored += int64(len(batch)) })
+	results, err := j.store.UpsertMatchingsByPair(ctx, batch)
+	if err != nil {
+		if ctx.Err() == nil {
+			j.recordErrors(int64(len(batch)), err)
+		}
+		return int64(len(batch))
+	}
+
+	var written, failed int64
+	var lastErr error
+	for _, result := range results {
+		if result.Status == BulkStatusFailed {
+			failed++
+			lastErr = errors.New(result.Error)
+			continue
+		}
+		written++
+	}
+	j.update(func(p *RecomputeProgress) { p.PairsWritten += written })
+	if failed > 0 {
+		j.recordErrors(failed, lastErr)
+	}
+	return failed
+}
+
+func (j *RecomputeJob) saveCheckpoint(lastSummaryID primitive.ObjectID) {
+	ctx, cancel := AddTimeoutContext(context.Background())
+	defer cancel()
+	checkpoint := JobCheckpoint{Job: recomputeJobName, LastSummaryId: lastSummaryID, UpdatedAt: time.Now().UTC()}
+	if err := j.store.SaveCheckpoint(ctx, checkpoint); err != nil {
+		j.recordErrors(1, fmt.Errorf("save checkpoint: %w", err))
+	}
+}
+
+func (j *RecomputeJob) recordErrors(count int64, err error) {
+	j.update(func(p *RecomputeProgress) {
+		p.Errors += count
+		p.LastError = err.Error()
+	})
+}
+
+func (j *RecomputeJob) update(fn func(p *RecomputeProgress)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	fn(&j.progress)
+}
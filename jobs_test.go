@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	iss "github.com/matryer/is"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestRecomputeJob(t *testing.T, summaries int) (*RecomputeJob, *MemoryStore, []primitive.ObjectID) {
+	store := NewMemoryStore()
+	ids := make([]primitive.ObjectID, summaries)
+	for i := range ids {
+		ids[i] = primitive.NewObjectIDFromTimestamp(time.Unix(int64(1600000000+i), 0))
+		store.PutSummary(ids[i], SummaryDocument{"skills": []interface{}{"go", i}})
+	}
+
+	scorer, err := NewWeightedOverlapScorer(OverlapRules{Fields: []OverlapRule{{Field: "skills", Weight: 1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := NewRecomputeJob(NewScoringEngine(store, scorer), store, 2)
+	job.pageSize = 3 // summaries are read by more pages
+	return job, store, ids
+}
+
+func TestRecomputeJob_Run(t *testing.T) {
+	is := iss.New(t)
+	job, store, _ := newTestRecomputeJob(t, 4)
+	ctx := context.Background()
+
+	is.NoErr(job.Run(ctx, false))
+	progress := job.Progress()
+	is.Equal(progress.State, JobStateDone)
+	is.Equal(progress.SummariesDone, int64(4))
+	is.Equal(progress.PairsScored, int64(12))
+	is.Equal(progress.PairsWritten, int64(12))
+	is.Equal(progress.Errors, int64(0))
+
+	matchings, err := store.GetAllMatchings(ctx)
+	is.NoErr(err)
+	is.Equal(len(matchings), 12)
+	for _, m := range matchings {
+		is.Equal(m.MatchRate, 33)
+	}
+
+	// second run updates existing pairs
+	is.NoErr(job.Run(ctx, false))
+	matchings, err = store.GetAllMatchings(ctx)
+	is.NoErr(err)
+	is.Equal(len(matchings), 12)
+
+	_, err = store.GetCheckpoint(ctx, recomputeJobName)
+	is.Equal(err, ErrNotFound)
+}
+
+func TestRecomputeJob_Resume(t *testing.T) {
+	is := iss.New(t)
+	job, store, ids := newTestRecomputeJob(t, 4)
+	ctx := context.Background()
+	is.NoErr(store.SaveCheckpoint(ctx, JobCheckpoint{Job: recomputeJobName, LastSummaryId: ids[1]}))
+
+	is.NoErr(job.Run(ctx, true))
+	progress := job.Progress()
+	is.Equal(progress.SummariesDone, int64(4))
+	is.Equal(progress.PairsWritten, int64(6))
+
+	for _, id := range ids[:2] {
+		matchings, err := store.GetMatchingsBySummaryId(ctx, id, SummaryMatchingsQuery{})
+		is.NoErr(err)
+		is.Equal(len(matchings), 0)
+	}
+}
+
+// failingUpsertStore fails upserts of matchings of summary failing.
+type failingUpsertStore struct {
+	*MemoryStore
+	failing primitive.ObjectID
+}
+
+func (s failingUpsertStore) UpsertMatchingsByPair(ctx context.Context, matchings []Matching) ([]BulkItemResult, error) {
+	if len(matchings) > 0 && matchings[0].SummaryId == s.failing {
+		return nil, errors.New("connection refused")
+	}
+	return s.MemoryStore.UpsertMatchingsByPair(ctx, matchings)
+}
+
+func TestRecomputeJob_WriteFailure(t *testing.T) {
+	is := iss.New(t)
+	job, store, ids := newTestRecomputeJob(t, 4)
+	job.store = failingUpsertStore{MemoryStore: store, failing: ids[1]}
+	ctx := context.Background()
+
+	err := job.Run(ctx, false)
+	is.True(err != nil)
+	progress := job.Progress()
+	is.Equal(progress.State, JobStateFailed)
+	is.Equal(progress.SummariesDone, int64(3))
+
+	// checkpoint stays before the summary which was not written
+	checkpoint, err := store.GetCheckpoint(ctx, recomputeJobName)
+	is.NoErr(err)
+	is.Equal(checkpoint.LastSummaryId, ids[0])
+
+	job.store = store
+	is.NoErr(job.Run(ctx, true))
+	matchings, err := store.GetMatchingsBySummaryId(ctx, ids[1], SummaryMatchingsQuery{})
+	is.NoErr(err)
+	is.Equal(len(matchings), 3)
+}
+
+// failingScorer fails scoring of summary failing.
+type failingScorer struct {
+	Scorer
+	failing primitive.ObjectID
+}
+
+func (s failingScorer) Score(summary, matchedSummary SummaryDocument) (int, error) {
+	if summary.ID() == s.failing {
+		return 0, errors.New("invalid skills")
+	}
+	return s.Scorer.Score(summary, matchedSummary)
+}
+
+func TestRecomputeJob_ScoreFailure(t *testing.T) {
+	is := iss.New(t)
+	job, store, ids := newTestRecomputeJob(t, 4)
+	scorer := job.engine.scorer
+	job.engine = NewScoringEngine(store, failingScorer{Scorer: scorer, failing: ids[1]})
+	ctx := context.Background()
+
+	err := job.Run(ctx, false)
+	is.True(err != nil)
+	progress := job.Progress()
+	is.Equal(progress.State, JobStateFailed)
+	is.Equal(progress.SummariesDone, int64(3))
+	is.Equal(progress.Errors, int64(3))
+
+	// checkpoint stays before the summary which was not scored
+	checkpoint, err := store.GetCheckpoint(ctx, recomputeJobName)
+	is.NoErr(err)
+	is.Equal(checkpoint.LastSummaryId, ids[0])
+
+	job.engine = NewScoringEngine(store, scorer)
+	is.NoErr(job.Run(ctx, true))
+	matchings, err := store.GetMatchingsBySummaryId(ctx, ids[1], SummaryMatchingsQuery{})
+	is.NoErr(err)
+	is.Equal(len(matchings), 3)
+}
+
+func TestRecomputeJob_Cancel(t *testing.T) {
+	is := iss.New(t)
+	job, _, _ := newTestRecomputeJob(t, 50)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := job.Run(ctx, false)
+	is.True(err != nil)
+	is.Equal(job.Progress().State, JobStateCancelled)
+}
+
+func TestRecomputeJob_Shutdown(t *testing.T) {
+	is := iss.New(t)
+	job, _, _ := newTestRecomputeJob(t, 200)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// idle job has nothing to wait for
+	is.NoErr(job.Shutdown(ctx))
+
+	is.NoErr(job.Start(context.Background(), false))
+	is.NoErr(job.Shutdown(ctx))
+	is.True(job.Progress().State != JobStateRunning)
+}
+
+func TestShutdownServers_stopsJobsWhenRequestsHang(t *testing.T) {
+	is := iss.New(t)
+	job, store, _ := newTestRecomputeJob(t, 200)
+	matchingServer := NewServer("test", store, WithScoring(job.engine, job))
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	api := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+	})}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	is.NoErr(err)
+	go api.Serve(listener)
+	go http.Get("http://" + listener.Addr().String())
+	<-entered
+
+	is.NoErr(job.Start(context.Background(), false))
+	err = shutdownServers(api, matchingServer, 100*time.Millisecond)
+	is.True(err != nil)
+	is.True(strings.HasPrefix(err.Error(), "could not stop server gracefully"))
+	is.True(job.Progress().State != JobStateRunning) // job is stopped although requests are not drained
+}
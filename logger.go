@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+)
+
+const headerRequestID = "X-Request-ID"
+
+// Level is the severity of the log line.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// ParseLevel returns level of its name: debug, info, warn or error.
+func ParseLevel(name string) (Level, error) {
+	for i, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q", name)
+}
+
+// Logger writes leveled log lines as JSON objects with time, level, msg and its fields.
+type Logger struct {
+	out    io.Writer
+	mu     *sync.Mutex
+	level  Level
+	fields []interface{}
+}
+
+// logger is the service logger, its level is set from Config.LogLevel.
+var logger = NewLogger(os.Stderr, LevelInfo)
+
+func NewLogger(out io.Writer, level Level) *Logger {
+	return &Logger{out: out, mu: &sync.Mutex{}, level: level}
+}
+
+// With returns logger adding passed key value pairs to every line.
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	child := *l
+	child.fields = append(append(make([]interface{}, 0, len(l.fields)+len(keyvals)), l.fields...), keyvals...)
+	return &child
+}
+
+// SetLevel changes level of the logger and all loggers derived from it later.
+func (l *Logger) SetLevel(level Level) {
+	l.level = level
+}
+
+func (l *Logger) Debug(msg string, keyvals ...interface{}) { l.log(LevelDebug, msg, keyvals) }
+func (l *Logger) Info(msg string, keyvals ...interface{})  { l.log(LevelInfo, msg, keyvals) }
+func (l *Logger) Warn(msg string, keyvals ...interface{})  { l.log(LevelWarn, msg, keyvals) }
+func (l *Logger) Error(msg string, keyvals ...interface{}) { l.log(LevelError, msg, keyvals) }
+
+func (l *Logger) log(level Level, msg string, keyvals []interface{}) {
+	if level < l.level {
+		return
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(`{"time":`)
+	writeLogValue(buf, time.Now().UTC().Format(time.RFC3339Nano))
+	buf.WriteString(`,"level":`)
+	writeLogValue(buf, level.String())
+	buf.WriteString(`,"msg":`)
+	writeLogValue(buf, msg)
+	for _, pairs := range [][]interface{}{l.fields, keyvals} {
+		for i := 0; i < len(pairs); i += 2 {
+			buf.WriteByte(',')
+			writeLogValue(buf, fmt.Sprint(pairs[i]))
+			buf.WriteByte(':')
+			if i+1 < len(pairs) {
+				writeLogValue(buf, pairs[i+1])
+			} else {
+				buf.WriteString("null")
+			}
+		}
+	}
+	buf.WriteString("}\n")
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(buf.Bytes())
+}
+
+// writeLogValue writes value as JSON, errors and values which can't be encoded are written as strings.
+func writeLogValue(buf *bytes.Buffer, value interface{}) {
+	switch v := value.(type) {
+	case error:
+		value = v.Error()
+	case time.Duration:
+		value = v.String()
+	case fmt.Stringer:
+		value = v.String()
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(value))
+	}
+	buf.Write(data)
+}
+
+// Write makes Logger the output of the standard log package, each write is logged as info line.
+func (l *Logger) Write(p []byte) (int, error) {
+	l.Info(strings.TrimSpace(string(p)))
+	return len(p), nil
+}
+
+type logContextKey int
+
+const requestLogContextKey logContextKey = 1
+
+// requestLog holds request fields which are known only after the request has been routed
+// and authenticated, so they can be added to every line logged during the request.
+type requestLog struct {
+	mu   sync.Mutex
+	user string
+}
+
+// setRequestUser records the authenticated caller of the request, token subject or API key.
+func setRequestUser(ctx context.Context, user string) {
+	if entry, ok := ctx.Value(requestLogContextKey).(*requestLog); ok {
+		entry.mu.Lock()
+		entry.user = user
+		entry.mu.Unlock()
+	}
+}
+
+// LoggerFromContext returns logger adding request id, route and user of the request to every line.
+func LoggerFromContext(ctx context.Context) *Logger {
+	keyvals := make([]interface{}, 0, 6)
+	if id := middleware.GetReqID(ctx); id != "" {
+		keyvals = append(keyvals, "request_id", id)
+	}
+	if rctx := chi.RouteContext(ctx); rctx != nil && rctx.RoutePattern() != "" {
+		keyvals = append(keyvals, "route", strings.ReplaceAll(rctx.RoutePattern(), "//", "/"))
+	}
+	if entry, ok := ctx.Value(requestLogContextKey).(*requestLog); ok {
+		entry.mu.Lock()
+		if entry.user != "" {
+			keyvals = append(keyvals, "user", entry.user)
+		}
+		entry.mu.Unlock()
+	}
+	if len(keyvals) == 0 {
+		return logger
+	}
+	return logger.With(keyvals...)
+}
+
+// RequestLogger logs every request with its status, size and latency and echoes
+// the request id in X-Request-ID header. It has to run after middleware.RequestID.
+func RequestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		w.Header().Set(headerRequestID, middleware.GetReqID(r.Context()))
+		ctx := context.WithValue(r.Context(), requestLogContextKey, &requestLog{})
+		r = r.WithContext(ctx)
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		log := LoggerFromContext(ctx).Info
+		if status >= http.StatusInternalServerError {
+			log = LoggerFromContext(ctx).Error
+		}
+		log("request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", status,
+			"bytes", ww.BytesWritten(),
+			"latency_ms", float64(time.Since(start).Microseconds())/1000,
+			"remote_ip", r.RemoteAddr)
+	})
+}
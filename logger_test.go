@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	iss "github.com/matryer/is"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func decodeLogLines(t *testing.T, out *bytes.Buffer) []map[string]interface{} {
+	lines := make([]map[string]interface{}, 0)
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		entry := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("invalid log line %q: %v", line, err)
+		}
+		lines = append(lines, entry)
+	}
+	return lines
+}
+
+func TestLogger(t *testing.T) {
+	is := iss.New(t)
+	out := &bytes.Buffer{}
+	l := NewLogger(out, LevelInfo).With("component", "test")
+
+	l.Debug("hidden")
+	l.Info("saved", "count", 2, "error", errors.New("boom"), "odd")
+	lines := decodeLogLines(t, out)
+	is.Equal(len(lines), 1)
+	is.Equal(lines[0]["level"], "info")
+	is.Equal(lines[0]["msg"], "saved")
+	is.Equal(lines[0]["component"], "test")
+	is.Equal(lines[0]["count"], 2.0)
+	is.Equal(lines[0]["error"], "boom")
+	is.Equal(lines[0]["odd"], nil)
+
+	_, err := ParseLevel("verbose")
+	is.True(err != nil)
+}
+
+func TestRequestLogger(t *testing.T) {
+	is := iss.New(t)
+	out := &bytes.Buffer{}
+	defaultLogger := logger
+	logger = NewLogger(out, LevelDebug)
+	defer func() { logger = defaultLogger }()
+
+	store := NewMemoryStore()
+	s := NewServer("test", NewMetricsStore(store, NewMetrics()), WithAPIKeys())
+	issued, err := s.apiKeys.Create(context.Background(), "crm", []string{RoleReader}, 0)
+	is.NoErr(err)
+	out.Reset()
+
+	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Use(RequestLogger)
+	r.Mount("/api/v1/matching", s.Router)
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/matching/summary/"+summaryId1, nil)
+	req.Header.Set(middleware.RequestIDHeader, "req-1")
+	req.Header.Set(headerAPIKey, issued.Key)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	is.Equal(w.Code, http.StatusOK)
+	is.Equal(w.Header().Get(headerRequestID), "req-1")
+
+	lines := decodeLogLines(t, out)
+	is.True(len(lines) > 1) // store operations and the request
+	for _, line := range lines {
+		is.Equal(line["request_id"], "req-1")
+	}
+	request := lines[len(lines)-1]
+	is.Equal(request["msg"], "request")
+	is.Equal(request["route"], "/api/v1/matching/summary/{summaryId}")
+	is.Equal(request["user"], apiKeySubjectPrefix+issued.Id.Hex())
+	is.Equal(request["status"], 200.0)
+	is.True(request["latency_ms"] != nil)
+}
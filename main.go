@@ -2,9 +2,12 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"strconv"
 	"time"
 
@@ -14,21 +17,47 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// Supported values of Config.DriverName.
+const (
+	driverMongoDB = "mongodb"
+	driverMemory  = "memory"
+)
+
 var (
 	mongoDbConfig Config
 	client        *mongo.Client
 )
 
 type Config struct {
-	Host            string
-	Port            int
-	ReadTimeout     time.Duration
-	WriteTimeout    time.Duration
-	ShutdownTimeout time.Duration
-	DriverName      string
-	DbHost          string
-	DbPort          string
-	DbName          string
+	Host            string        `yaml:"host"`
+	Port            int           `yaml:"port"`
+	ReadTimeout     time.Duration `yaml:"readTimeout"`
+	WriteTimeout    time.Duration `yaml:"writeTimeout"`
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+	DriverName      string        `yaml:"driver"`
+	// DbURI is the full MongoDB connection URI with credentials, TLS and replicaSet options.
+	// DbHost and DbPort are used when not set.
+	DbURI  string `yaml:"dbUri"`
+	DbHost string `yaml:"dbHost"`
+	DbPort string `yaml:"dbPort"`
+	DbName string `yaml:"dbName"`
+	// ScoringRulesFile is the path to the rules file of weighted overlap scorer.
+	// Scoring is disabled when not set.
+	ScoringRulesFile string `yaml:"scoringRulesFile"`
+	// RecomputeWorkers is the number of workers of the recompute job, 0 means number of CPUs.
+	RecomputeWorkers int `yaml:"recomputeWorkers"`
+	// CheckSummaries enables check that summaries of the written matching exist.
+	CheckSummaries bool `yaml:"checkSummaries"`
+	// Auth configures JWT verification, API is open when no key file is set and APIKeys is false.
+	Auth AuthConfig `yaml:"auth"`
+	// APIKeys accepts API keys managed by apikey command and admin endpoints.
+	APIKeys bool `yaml:"apiKeys"`
+	// RateLimits are requests per minute of a single client per route group, 0 means no limit.
+	RateLimits RateLimits `yaml:"rateLimits"`
+	// LogLevel is the minimal level of logged lines: debug, info, warn or error.
+	LogLevel string `yaml:"logLevel"`
+	// Versions routes reads of matchings to namespaces of scorer versions.
+	Versions VersionRouting `yaml:"versions"`
 }
 
 // Addr returns server address in the form of Host:Port localhost:8080.
@@ -37,9 +66,41 @@ func (sc Config) Addr() string {
 }
 
 func main() {
-	config := newConfig()
-	if err := startAPIServerAndWait(config); err != nil {
-		log.Fatal(err)
+	flag.Usage = usage
+	config, err := loadConfig(flag.CommandLine, os.Args[1:], os.LookupEnv)
+	if err != nil {
+		logger.Error("invalid config", "error", err)
+		os.Exit(1)
+	}
+	level, _ := ParseLevel(config.LogLevel)
+	logger.SetLevel(level)
+	// lines of the standard log package, e.g. of http.Server, are written as JSON info lines
+	log.SetFlags(0)
+	log.SetOutput(logger)
+
+	switch command := flag.Arg(0); command {
+	case "", "serve":
+		err = startAPIServerAndWait(config)
+	case "recompute":
+		err = runRecomputeCommand(config, flag.Args()[1:])
+	case "dedupe":
+		err = runDedupeCommand(config, flag.Args()[1:])
+	case "verify":
+		err = runVerifyCommand(config, flag.Args()[1:])
+	case "apikey":
+		err = runAPIKeyCommand(config, flag.Args()[1:])
+	case "assign":
+		err = runAssignCommand(config, flag.Args()[1:])
+	case "compare":
+		err = runCompareCommand(config, flag.Args()[1:])
+	case "config":
+		err = runConfigCommand(config, flag.Args()[1:])
+	default:
+		err = fmt.Errorf("unknown command %q", command)
+	}
+	if err != nil {
+		logger.Error("command failed", "command", flag.Arg(0), "error", err)
+		os.Exit(1)
 	}
 }
 
@@ -47,21 +108,21 @@ func newConfig() Config {
 	return Config{
 		Host:            "localhost",
 		Port:            8090,
-		DriverName:      "mongodb",
+		DriverName:      driverMongoDB,
 		DbHost:          "localhost", //# aws winawin mongodb public ip
 		DbPort:          "27017",
 		DbName:          "winawin_test",
 		ReadTimeout:     time.Second * 5,
 		WriteTimeout:    time.Second * 5,
 		ShutdownTimeout: time.Second * 5,
+		LogLevel:        LevelInfo.String(),
 	}
 }
 
 func NewMongoClient(conf Config) (*mongo.Client, error) {
 	mongoDbConfig = conf
 
-	mongoURI := fmt.Sprintf("%s://%s:%s/?retryWrites=false",
-		mongoDbConfig.DriverName, mongoDbConfig.DbHost, mongoDbConfig.DbPort)
+	mongoURI := mongoDbConfig.MongoURI()
 	clientOptions := options.Client().ApplyURI(mongoURI)
 	var err error
 	if client, err = mongo.Connect(context.TODO(), clientOptions); err != nil {
@@ -73,44 +134,192 @@ func NewMongoClient(conf Config) (*mongo.Client, error) {
 		return client, err
 	}
 
-	log.Printf("Connected to MongoDB!")
-	log.Printf("    Connection URL: %s\n\n", mongoURI)
+	logger.Info("connected to MongoDB", "uri", redactURI(mongoURI))
 	return client, nil
 }
 
-func startAPIServerAndWait(config Config) error {
+// openDataStore opens the store selected by Config.DriverName.
+// Returned close function disconnects from the database.
+func openDataStore(config Config) (DataStore, func(), error) {
+	if config.DriverName == driverMemory {
+		logger.Info("using in-memory store")
+		return NewMemoryStore(), func() {}, nil
+	}
+
 	mongoClient, err := NewMongoClient(config)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	defer func() {
-		log.Printf("main : Database Stopping : %s", config.DbHost)
-		if err := mongoClient.Disconnect(context.TODO()); err != nil {
-			log.Panic(err)
+	closeFn := func() {
+		logger.Info("database stopping", "db_host", config.DbHost)
+		ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+		defer cancel()
+		if err := mongoClient.Disconnect(ctx); err != nil {
+			logger.Error("database stopping failed", "error", err)
 		}
-	}()
+	}
+	return NewRepo(mongoClient, config.DbName), closeFn, nil
+}
+
+func startAPIServerAndWait(config Config) error {
+	store, closeStore, err := openDataStore(config)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
 
+	if err := ensureIndexes(store); errors.Is(err, ErrConflict) {
+		// duplicated pairs written by older versions don't stop the service, readiness reports the missing index
+		logger.Warn("started without unique summary pair index", "error", err)
+	} else if err != nil {
+		return err
+	}
 
-	log.Printf("int-matching : API server listening on %s", config.Addr())
+	scoring, err := newScoringEngine(config, store)
+	if err != nil {
+		return err
+	}
 
-	_, err = startAPIServer(config, mongoClient)
+	authenticator, err := newAuthenticator(config)
 	if err != nil {
 		return err
 	}
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	api, matchingServer := newAPIServer(config, store, scoring, authenticator)
+	serverErrors := make(chan error, 1)
+	go func() {
+		logger.Info("API server listening", "addr", config.Addr())
+		serverErrors <- api.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErrors:
+		return fmt.Errorf("server error: %w", err)
+	case <-ctx.Done():
+	}
+
+	logger.Info("start shutdown", "timeout", config.ShutdownTimeout)
+	if err := shutdownServers(api, matchingServer, config.ShutdownTimeout); err != nil {
+		return err
+	}
+	logger.Info("shutdown complete")
 	return nil
 }
 
-func startAPIServer(cfg Config,	mongoClient *mongo.Client) (*http.Server, error) {
+// shutdownServers stops accepting connections and drains in-flight requests first, so no new job
+// can be started, then cancels background jobs and waits for them, each within timeout.
+// Jobs are stopped even when requests are not drained in time, so the store can be closed after it.
+func shutdownServers(api *http.Server, matchingServer *Server, timeout time.Duration) error {
+	apiCtx, cancelAPI := context.WithTimeout(context.Background(), timeout)
+	defer cancelAPI()
+	apiErr := api.Shutdown(apiCtx)
+	if apiErr != nil {
+		api.Close()
+	}
+
+	jobsCtx, cancelJobs := context.WithTimeout(context.Background(), timeout)
+	defer cancelJobs()
+	jobsErr := matchingServer.Shutdown(jobsCtx)
+
+	switch {
+	case apiErr != nil && jobsErr != nil:
+		return fmt.Errorf("could not stop server gracefully: %v, could not stop background jobs: %w", apiErr, jobsErr)
+	case apiErr != nil:
+		return fmt.Errorf("could not stop server gracefully: %w", apiErr)
+	case jobsErr != nil:
+		return fmt.Errorf("could not stop background jobs: %w", jobsErr)
+	}
+	return nil
+}
+
+// ensureIndexes creates indexes required by the service, including unique summary pair index.
+func ensureIndexes(store MaintenanceStore) error {
+	ctx, cancel := AddTimeoutContext(context.Background())
+	defer cancel()
+	if err := store.EnsureIndexes(ctx); err != nil {
+		if errors.Is(err, ErrConflict) {
+			return fmt.Errorf("%w, remove duplicated matchings with dedupe command", err)
+		}
+		return err
+	}
+	return nil
+}
+
+// newScoringEngine creates scoring engine with weighted overlap scorer configured by the rules file.
+// It returns nil engine when rules file is not configured.
+func newScoringEngine(cfg Config, summaries SummaryStore) (*ScoringEngine, error) {
+	if cfg.ScoringRulesFile == "" {
+		return nil, nil
+	}
+
+	rules, err := LoadOverlapRules(cfg.ScoringRulesFile)
+	if err != nil {
+		return nil, err
+	}
+	scorer, err := NewWeightedOverlapScorer(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("scoring enabled", "scorer", scorer.Name(), "rules", cfg.ScoringRulesFile)
+	return NewScoringEngine(summaries, scorer), nil
+}
+
+// newAuthenticator creates JWT authenticator, it returns nil when no verification key is configured.
+func newAuthenticator(cfg Config) (*Authenticator, error) {
+	if !cfg.Auth.Enabled() {
+		logger.Warn("authentication disabled, API is open")
+		return nil, nil
+	}
+	return NewAuthenticator(cfg.Auth)
+}
+
+// newAPIServer creates http server with matching API mounted at /api/v1/matching.
+// Returned matching Server owns background jobs which have to be stopped on shutdown.
+func newAPIServer(cfg Config, store DataStore, scoring *ScoringEngine, authenticator *Authenticator) (*http.Server, *Server) {
+
+	metrics := NewMetrics()
+	store = NewMetricsStore(store, metrics)
+	metrics.AddCollectionGauge(store)
 
 	r := chi.NewRouter()
 	// A good base middleware stack
 	r.Use(middleware.RequestID)
 	r.Use(middleware.RealIP)
-	r.Use(middleware.Logger)
+	r.Use(RequestLogger)
+	r.Use(metrics.Middleware)
 	r.Use(middleware.Recoverer)
 
-	matchingServer := NewServer("development", cfg.DbName, mongoClient)
+	opts := make([]ServerOption, 0)
+	if scoring != nil {
+		recompute := NewRecomputeJob(scoring, store, cfg.RecomputeWorkers)
+		metrics.AddRecomputeJobGauges(recompute)
+		opts = append(opts, WithScoring(scoring, recompute))
+	}
+	if cfg.CheckSummaries {
+		opts = append(opts, WithSummaryChecks())
+	}
+	if authenticator != nil {
+		opts = append(opts, WithAuthenticator(authenticator))
+	}
+	if cfg.APIKeys {
+		opts = append(opts, WithAPIKeys())
+	}
+	if cfg.RateLimits.Enabled() {
+		opts = append(opts, WithRateLimits(cfg.RateLimits))
+	}
+	if cfg.Versions.Enabled() {
+		opts = append(opts, WithVersionRouting(cfg.Versions))
+	}
+
+	matchingServer := NewServer("development", store, opts...)
+	r.Get("/healthz", matchingServer.healthzHandler)
+	r.Get("/readyz", matchingServer.readyzHandler)
+	r.Get("/metrics", metrics.Handler)
 	r.Mount("/api/v1/matching", matchingServer.Router)
 
 	server := http.Server{
@@ -119,10 +328,5 @@ func startAPIServer(cfg Config,	mongoClient *mongo.Client) (*http.Server, error)
 		ReadTimeout:  cfg.ReadTimeout,
 		WriteTimeout: cfg.WriteTimeout,
 	}
-
-	err := server.ListenAndServe()
-	if err != nil {
-		return nil, err
-	}
-	return &server, nil
+	return &server, matchingServer
 }
@@ -0,0 +1,750 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a thread-safe in-memory MatchingStore.
+// It is used by handler tests and to run the server in development mode without a database.
+type MemoryStore struct {
+	mu          sync.RWMutex
+	matchings   map[primitive.ObjectID]Matching
+	summaries   map[primitive.ObjectID]SummaryDocument
+	checkpoints map[string]JobCheckpoint
+	audits      []ErasureAudit
+	apiKeys     map[primitive.ObjectID]APIKey
+	namespaces  map[string]*MemoryStore
+}
+
+// NewMemoryStore creates an empty in-memory matching store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		matchings:   make(map[primitive.ObjectID]Matching),
+		summaries:   make(map[primitive.ObjectID]SummaryDocument),
+		checkpoints: make(map[string]JobCheckpoint),
+		apiKeys:     make(map[primitive.ObjectID]APIKey),
+		namespaces:  make(map[string]*MemoryStore),
+	}
+}
+
+// PutSummary stores summary document under passed id, _id of the document is set to id.
+func (s *MemoryStore) PutSummary(id primitive.ObjectID, summary SummaryDocument) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	doc := make(SummaryDocument, len(summary)+1)
+	for key, value := range summary {
+		doc[key] = value
+	}
+	doc["_id"] = id
+	s.summaries[id] = doc
+}
+
+func (s *MemoryStore) GetSummary(ctx context.Context, id primitive.ObjectID) (SummaryDocument, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	summary, ok := s.summaries[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return summary, nil
+}
+
+// GetSummariesAfter returns at most limit summary documents with _id greater than afterID ordered by _id.
+func (s *MemoryStore) GetSummariesAfter(ctx context.Context, afterID primitive.ObjectID, limit int) ([]SummaryDocument, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := s.summariesAfter(afterID)
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i].ID(), result[j].ID()
+		return bytes.Compare(a[:], b[:]) < 0
+	})
+	if len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+// CountSummariesAfter returns the number of summaries with _id greater than afterID.
+func (s *MemoryStore) CountSummariesAfter(ctx context.Context, afterID primitive.ObjectID) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return int64(len(s.summariesAfter(afterID))), nil
+}
+
+func (s *MemoryStore) summariesAfter(afterID primitive.ObjectID) []SummaryDocument {
+	result := make([]SummaryDocument, 0)
+	for id, summary := range s.summaries {
+		if bytes.Compare(id[:], afterID[:]) > 0 {
+			result = append(result, summary)
+		}
+	}
+	return result
+}
+
+func (s *MemoryStore) ExistingSummaryIds(ctx context.Context, ids []primitive.ObjectID) (map[primitive.ObjectID]bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	existing := make(map[primitive.ObjectID]bool, len(ids))
+	for _, id := range ids {
+		if _, ok := s.summaries[id]; ok {
+			existing[id] = true
+		}
+	}
+	return existing, nil
+}
+
+func (s *MemoryStore) GetMatching(ctx context.Context, id primitive.ObjectID) (Matching, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	matching, ok := s.matchings[id]
+	if !ok {
+		return Matching{}, ErrNotFound
+	}
+	return matching, nil
+}
+
+// GetMatchingBySummaryId returns the first matching for passed summaryId.
+func (s *MemoryStore) GetMatchingBySummaryId(ctx context.Context, summaryID primitive.ObjectID) (Matching, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, matching := range s.sorted() {
+		if matching.SummaryId == summaryID {
+			return *matching, nil
+		}
+	}
+	return Matching{}, ErrNotFound
+}
+
+// GetMatchingsBySummaryId returns matchings for passed summaryId ranked by matchRate
+// in descending order and narrowed down by passed query.
+func (s *MemoryStore) GetMatchingsBySummaryId(ctx context.Context, summaryID primitive.ObjectID, query SummaryMatchingsQuery) ([]*Matching, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	excluded := make(map[primitive.ObjectID]bool, len(query.Exclude))
+	for _, id := range query.Exclude {
+		excluded[id] = true
+	}
+
+	result := make([]*Matching, 0)
+	for _, matching := range s.sorted() {
+		if matching.SummaryId != summaryID || (query.MinRate > 0 && matching.MatchRate < query.MinRate) || excluded[matching.MatchedSummaryId] {
+			continue
+		}
+		result = append(result, matching)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].MatchRate > result[j].MatchRate
+	})
+	if query.Limit > 0 && len(result) > query.Limit {
+		result = result[:query.Limit]
+	}
+	return result, nil
+}
+
+// GetMutualMatchings returns pairs of summaryId matched in both directions with matchRate
+// of at least query.MinRate, ranked by the combined score.
+func (s *MemoryStore) GetMutualMatchings(ctx context.Context, summaryID primitive.ObjectID, query MutualMatchingsQuery) ([]MutualMatching, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	all := s.sorted()
+	result := make([]MutualMatching, 0)
+	for _, forward := range all {
+		if forward.SummaryId != summaryID || forward.MatchRate < query.MinRate {
+			continue
+		}
+		for _, reverse := range all {
+			if reverse.SummaryId != forward.MatchedSummaryId || reverse.MatchedSummaryId != summaryID || reverse.MatchRate < query.MinRate {
+				continue
+			}
+			result = append(result, MutualMatching{
+				SummaryId:         summaryID,
+				MatchedSummaryId:  forward.MatchedSummaryId,
+				MatchRate:         forward.MatchRate,
+				ReverseMatchRate:  reverse.MatchRate,
+				Score:             combineRates(query.Combine, forward.MatchRate, reverse.MatchRate),
+				MatchingId:        forward.Id,
+				ReverseMatchingId: reverse.Id,
+			})
+		}
+	}
+
+	sortMutualMatchings(result)
+	if query.Limit > 0 && len(result) > query.Limit {
+		result = result[:query.Limit]
+	}
+	return result, nil
+}
+
+// GetAllMatchings returns all stored matchings ordered by Id.
+func (s *MemoryStore) GetAllMatchings(ctx context.Context) ([]*Matching, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sorted(), nil
+}
+
+// IterateMatchingsBySummary returns snapshot of stored matchings grouped by summary.
+func (s *MemoryStore) IterateMatchingsBySummary(ctx context.Context) (MatchingsIterator, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	all := s.sorted()
+	sort.SliceStable(all, func(i, j int) bool {
+		return bytes.Compare(all[i].SummaryId[:], all[j].SummaryId[:]) < 0
+	})
+	return &sliceMatchingsIterator{matchings: all}, nil
+}
+
+// GetMatchingsBetween returns matchings of any of summaryIDs with any of matchedSummaryIDs ordered by Id.
+func (s *MemoryStore) GetMatchingsBetween(ctx context.Context, summaryIDs, matchedSummaryIDs []primitive.ObjectID) ([]*Matching, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	summaries := make(map[primitive.ObjectID]bool, len(summaryIDs))
+	for _, id := range summaryIDs {
+		summaries[id] = true
+	}
+	matched := make(map[primitive.ObjectID]bool, len(matchedSummaryIDs))
+	for _, id := range matchedSummaryIDs {
+		matched[id] = true
+	}
+
+	result := make([]*Matching, 0)
+	for _, matching := range s.sorted() {
+		if summaries[matching.SummaryId] && matched[matching.MatchedSummaryId] {
+			result = append(result, matching)
+		}
+	}
+	return result, nil
+}
+
+// FindMatchings returns a page of matchings passing the query filters, sorted by the query sort order.
+func (s *MemoryStore) FindMatchings(ctx context.Context, query MatchingsQuery) (MatchingsPage, error) {
+	page := MatchingsPage{Matchings: make([]*Matching, 0)}
+	field, desc, err := query.sortField()
+	if err != nil {
+		return page, err
+	}
+
+	var cursor *pageCursor
+	if query.After != "" {
+		c, err := decodePageCursor(query.After, query.Sort)
+		if err != nil {
+			return page, err
+		}
+		cursor = &c
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	filtered := make([]*Matching, 0)
+	for _, matching := range s.sorted() {
+		if query.matches(matching) {
+			filtered = append(filtered, matching)
+		}
+	}
+	page.Total = int64(len(filtered))
+
+	less := func(a, b *Matching) bool {
+		if c := compareSortField(field, a, b); c != 0 {
+			return (c < 0) != desc
+		}
+		return bytes.Compare(a.Id[:], b.Id[:]) < 0
+	}
+	sort.SliceStable(filtered, func(i, j int) bool {
+		return less(filtered[i], filtered[j])
+	})
+
+	if cursor != nil {
+		last := &Matching{Id: cursor.Id, MatchRate: cursor.Rate, CreatedAt: cursor.CreatedAt}
+		start := sort.Search(len(filtered), func(i int) bool {
+			return less(last, filtered[i])
+		})
+		filtered = filtered[start:]
+	}
+
+	limit := query.pageLimit()
+	if len(filtered) > limit {
+		filtered = filtered[:limit]
+		page.Next = encodePageCursor(query.Sort, filtered[limit-1])
+	}
+	page.Matchings = filtered
+	return page, nil
+}
+
+// compareSortField compares sorted field of two matchings, returns -1, 0 or 1.
+func compareSortField(field string, a, b *Matching) int {
+	switch field {
+	case "matchRate":
+		switch {
+		case a.MatchRate < b.MatchRate:
+			return -1
+		case a.MatchRate > b.MatchRate:
+			return 1
+		}
+	case "createdAt":
+		switch {
+		case a.CreatedAt.Before(b.CreatedAt):
+			return -1
+		case a.CreatedAt.After(b.CreatedAt):
+			return 1
+		}
+	}
+	return 0
+}
+
+// CreateMatching stores matching of a new summary pair, existing matching of the pair is updated instead.
+func (s *MemoryStore) CreateMatching(ctx context.Context, matching Matching) (primitive.ObjectID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, _ := s.upsertPair(matching)
+	return id, nil
+}
+
+func (s *MemoryStore) UpdateMatching(ctx context.Context, matching Matching) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if primitive.NilObjectID == matching.Id {
+		s.upsertPair(matching)
+		return 1, nil
+	}
+
+	current, ok := s.matchings[matching.Id]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	if pairID, ok := s.findPair(matching.SummaryId, matching.MatchedSummaryId); ok && pairID != matching.Id {
+		return 0, fmt.Errorf("%w: matching of the summary pair already exists", ErrConflict)
+	}
+	if reflect.DeepEqual(current, matching) {
+		return 0, nil
+	}
+	s.matchings[matching.Id] = matching
+	return 1, nil
+}
+
+func (s *MemoryStore) DeleteMatching(ctx context.Context, id primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.matchings[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.matchings, id)
+	return nil
+}
+
+func (s *MemoryStore) BulkWriteMatchings(ctx context.Context, matchings []Matching) ([]BulkItemResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bulkWrite(matchings), nil
+}
+
+// UpsertMatchingsByPair updates matchings of the same summaryId and matchedSummaryId pair
+// or creates new ones. Ids of passed matchings are ignored.
+func (s *MemoryStore) UpsertMatchingsByPair(ctx context.Context, matchings []Matching) ([]BulkItemResult, error) {
+	pairs := make([]Matching, len(matchings))
+	for i, matching := range matchings {
+		matching.Id = primitive.NilObjectID
+		pairs[i] = matching
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bulkWrite(pairs), nil
+}
+
+// bulkWrite upserts matchings without Id by pair and the rest by Id. Caller must hold write lock.
+func (s *MemoryStore) bulkWrite(matchings []Matching) []BulkItemResult {
+	results := make([]BulkItemResult, len(matchings))
+	for i, matching := range matchings {
+		results[i].Index = i
+		if primitive.NilObjectID == matching.Id {
+			id, created := s.upsertPair(matching)
+			results[i].Id = id
+			results[i].Status = BulkStatusUpdated
+			if created {
+				results[i].Status = BulkStatusCreated
+			}
+			continue
+		}
+
+		results[i].Id = matching.Id
+		if pairID, ok := s.findPair(matching.SummaryId, matching.MatchedSummaryId); ok && pairID != matching.Id {
+			results[i].Status = BulkStatusFailed
+			results[i].Error = ErrConflict.Error()
+			continue
+		}
+
+		results[i].Status = BulkStatusUpdated
+		if _, ok := s.matchings[matching.Id]; !ok {
+			results[i].Status = BulkStatusCreated
+		}
+		s.matchings[matching.Id] = matching
+	}
+	return results
+}
+
+// EnsureIndexes returns ErrConflict when duplicated summary pairs exist, MemoryStore
+// does not create duplicates, but tests can put them directly.
+func (s *MemoryStore) EnsureIndexes(ctx context.Context) error {
+	result, err := s.DedupeMatchings(ctx, true)
+	if err != nil {
+		return err
+	}
+	if result.Removed > 0 {
+		return fmt.Errorf("%w: %d summary pairs have duplicated matchings", ErrConflict, result.Pairs)
+	}
+	return nil
+}
+
+// DedupeMatchings removes duplicated matchings of the same summary pair, matching with
+// the newest createdAt is kept.
+func (s *MemoryStore) DedupeMatchings(ctx context.Context, dryRun bool) (DedupeResult, error) {
+	if dryRun {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+	} else {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	}
+
+	result := DedupeResult{}
+	newest := make(map[[2]primitive.ObjectID]*Matching)
+	duplicates := make([]primitive.ObjectID, 0)
+	for _, matching := range s.sorted() {
+		pair := [2]primitive.ObjectID{matching.SummaryId, matching.MatchedSummaryId}
+		kept, ok := newest[pair]
+		if !ok {
+			newest[pair] = matching
+			continue
+		}
+
+		if kept.CreatedAt.After(matching.CreatedAt) {
+			duplicates = append(duplicates, matching.Id)
+		} else {
+			duplicates = append(duplicates, kept.Id)
+			newest[pair] = matching
+		}
+		result.Removed++
+	}
+
+	counted := make(map[[2]primitive.ObjectID]bool)
+	for _, id := range duplicates {
+		matching := s.matchings[id]
+		pair := [2]primitive.ObjectID{matching.SummaryId, matching.MatchedSummaryId}
+		if !counted[pair] {
+			counted[pair] = true
+			result.Pairs++
+		}
+		if !dryRun {
+			delete(s.matchings, id)
+		}
+	}
+	return result, nil
+}
+
+// VerifyMatchings finds matchings referencing summaries which do not exist
+// and removes them when remove is true.
+func (s *MemoryStore) VerifyMatchings(ctx context.Context, remove bool) (VerifyReport, error) {
+	if remove {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	} else {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+	}
+
+	report := VerifyReport{Checked: int64(len(s.matchings)), Orphans: make([]OrphanMatching, 0)}
+	for _, matching := range s.sorted() {
+		_, hasSummary := s.summaries[matching.SummaryId]
+		_, hasMatchedSummary := s.summaries[matching.MatchedSummaryId]
+		if hasSummary && hasMatchedSummary {
+			continue
+		}
+
+		report.Orphans = append(report.Orphans, OrphanMatching{
+			Id:                    matching.Id,
+			SummaryId:             matching.SummaryId,
+			MatchedSummaryId:      matching.MatchedSummaryId,
+			MissingSummary:        !hasSummary,
+			MissingMatchedSummary: !hasMatchedSummary,
+		})
+		if remove {
+			delete(s.matchings, matching.Id)
+			report.Removed++
+		}
+	}
+	return report, nil
+}
+
+// GetSummaryIdsByProfile returns ids of all summaries with profileId field equal to passed profileID.
+func (s *MemoryStore) GetSummaryIdsByProfile(ctx context.Context, profileID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ids := make([]primitive.ObjectID, 0)
+	for id, summary := range s.summaries {
+		if summary["profileId"] == profileID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return bytes.Compare(ids[i][:], ids[j][:]) < 0
+	})
+	return ids, nil
+}
+
+func (s *MemoryStore) DeleteMatchingsBySummaries(ctx context.Context, summaryIDs []primitive.ObjectID) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	erased := make(map[primitive.ObjectID]bool, len(summaryIDs))
+	for _, id := range summaryIDs {
+		erased[id] = true
+	}
+
+	var deleted int64
+	for id, matching := range s.matchings {
+		if erased[matching.SummaryId] || erased[matching.MatchedSummaryId] {
+			delete(s.matchings, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (s *MemoryStore) SaveErasureAudit(ctx context.Context, audit ErasureAudit) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.audits {
+		if s.audits[i].Id == audit.Id {
+			s.audits[i] = audit
+			return nil
+		}
+	}
+	s.audits = append(s.audits, audit)
+	return nil
+}
+
+// Ping always succeeds, memory store has no connection.
+func (s *MemoryStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+// MissingIndexes returns no indexes, memory store enforces unique pairs itself.
+func (s *MemoryStore) MissingIndexes(ctx context.Context) ([]string, error) {
+	return []string{}, nil
+}
+
+func (s *MemoryStore) CountMatchings(ctx context.Context) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return int64(len(s.matchings)), nil
+}
+
+func (s *MemoryStore) GetCheckpoint(ctx context.Context, job string) (JobCheckpoint, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	checkpoint, ok := s.checkpoints[job]
+	if !ok {
+		return JobCheckpoint{}, ErrNotFound
+	}
+	return checkpoint, nil
+}
+
+func (s *MemoryStore) SaveCheckpoint(ctx context.Context, checkpoint JobCheckpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checkpoints[checkpoint.Job] = checkpoint
+	return nil
+}
+
+func (s *MemoryStore) DeleteCheckpoint(ctx context.Context, job string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.checkpoints, job)
+	return nil
+}
+
+func (s *MemoryStore) GetAPIKey(ctx context.Context, id primitive.ObjectID) (APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, ok := s.apiKeys[id]
+	if !ok {
+		return APIKey{}, ErrNotFound
+	}
+	return key, nil
+}
+
+// GetAllAPIKeys returns all keys, revoked included, ordered by creation.
+func (s *MemoryStore) GetAllAPIKeys(ctx context.Context) ([]APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]APIKey, 0, len(s.apiKeys))
+	for _, key := range s.apiKeys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i].Id[:], keys[j].Id[:]) < 0
+	})
+	return keys, nil
+}
+
+func (s *MemoryStore) SaveAPIKey(ctx context.Context, key APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.apiKeys[key.Id] = key
+	return nil
+}
+
+func (s *MemoryStore) TouchAPIKey(ctx context.Context, id primitive.ObjectID, usedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if key, ok := s.apiKeys[id]; ok {
+		key.LastUsedAt = &usedAt
+		s.apiKeys[id] = key
+	}
+	return nil
+}
+
+// findPair returns id of the oldest matching of passed pair. Caller must hold read lock.
+func (s *MemoryStore) findPair(summaryID, matchedSummaryID primitive.ObjectID) (primitive.ObjectID, bool) {
+	found := false
+	oldest := primitive.NilObjectID
+	for id, matching := range s.matchings {
+		if matching.SummaryId != summaryID || matching.MatchedSummaryId != matchedSummaryID {
+			continue
+		}
+		if !found || bytes.Compare(id[:], oldest[:]) < 0 {
+			oldest = id
+		}
+		found = true
+	}
+	return oldest, found
+}
+
+// upsertPair updates matching of the same summary pair, or inserts new one.
+// Caller must hold write lock.
+func (s *MemoryStore) upsertPair(matching Matching) (primitive.ObjectID, bool) {
+	if id, ok := s.findPair(matching.SummaryId, matching.MatchedSummaryId); ok {
+		matching.Id = id
+		s.matchings[id] = matching
+		return id, false
+	}
+	return s.insert(matching), true
+}
+
+// insert stores passed matching under a new Id. Caller must hold write lock.
+func (s *MemoryStore) insert(matching Matching) primitive.ObjectID {
+	matching.Id = primitive.NewObjectID()
+	s.matchings[matching.Id] = matching
+	return matching.Id
+}
+
+// sorted returns copies of stored matchings ordered by Id. Caller must hold read lock.
+func (s *MemoryStore) sorted() []*Matching {
+	result := make([]*Matching, 0, len(s.matchings))
+	for _, matching := range s.matchings {
+		m := matching
+		result = append(result, &m)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return bytes.Compare(result[i].Id[:], result[j].Id[:]) < 0
+	})
+	return result
+}
+
+// Namespace returns the store of matchings and job checkpoints of the scorer version,
+// namespace is created on the first use. Other data is kept by s.
+func (s *MemoryStore) Namespace(version string) (DataStore, error) {
+	version = namespaceVersion(version)
+	if version == "" {
+		return s, nil
+	}
+	if err := validateScorerVersion(version); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	namespace, ok := s.namespaces[version]
+	if !ok {
+		namespace = NewMemoryStore()
+		s.namespaces[version] = namespace
+	}
+	return memoryNamespace{MemoryStore: namespace, parent: s}, nil
+}
+
+// Namespaces returns versions of created namespaces ordered by name.
+func (s *MemoryStore) Namespaces(ctx context.Context) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	versions := make([]string, 0, len(s.namespaces))
+	for version := range s.namespaces {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+	return versions, nil
+}
+
+// memoryNamespace keeps matchings of a scorer version apart, everything else is read from parent.
+type memoryNamespace struct {
+	*MemoryStore
+	parent *MemoryStore
+}
+
+func (n memoryNamespace) GetSummary(ctx context.Context, id primitive.ObjectID) (SummaryDocument, error) {
+	return n.parent.GetSummary(ctx, id)
+}
+
+func (n memoryNamespace) GetSummariesAfter(ctx context.Context, afterID primitive.ObjectID, limit int) ([]SummaryDocument, error) {
+	return n.parent.GetSummariesAfter(ctx, afterID, limit)
+}
+
+func (n memoryNamespace) CountSummariesAfter(ctx context.Context, afterID primitive.ObjectID) (int64, error) {
+	return n.parent.CountSummariesAfter(ctx, afterID)
+}
+
+func (n memoryNamespace) ExistingSummaryIds(ctx context.Context, ids []primitive.ObjectID) (map[primitive.ObjectID]bool, error) {
+	return n.parent.ExistingSummaryIds(ctx, ids)
+}
+
+func (n memoryNamespace) GetSummaryIdsByProfile(ctx context.Context, profileID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	return n.parent.GetSummaryIdsByProfile(ctx, profileID)
+}
+
+func (n memoryNamespace) SaveErasureAudit(ctx context.Context, audit ErasureAudit) error {
+	return n.parent.SaveErasureAudit(ctx, audit)
+}
+
+func (n memoryNamespace) GetAPIKey(ctx context.Context, id primitive.ObjectID) (APIKey, error) {
+	return n.parent.GetAPIKey(ctx, id)
+}
+
+func (n memoryNamespace) GetAllAPIKeys(ctx context.Context) ([]APIKey, error) {
+	return n.parent.GetAllAPIKeys(ctx)
+}
+
+func (n memoryNamespace) SaveAPIKey(ctx context.Context, key APIKey) error {
+	return n.parent.SaveAPIKey(ctx, key)
+}
+
+func (n memoryNamespace) TouchAPIKey(ctx context.Context, id primitive.ObjectID, usedAt time.Time) error {
+	return n.parent.TouchAPIKey(ctx, id, usedAt)
+}
+
+func (n memoryNamespace) Namespace(version string) (DataStore, error) {
+	return n.parent.Namespace(version)
+}
+
+func (n memoryNamespace) Namespaces(ctx context.Context) ([]string, error) {
+	return n.parent.Namespaces(ctx)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	iss "github.com/matryer/is"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"testing"
+	"time"
+)
+
+// putMatching stores matching in the store as it is, without checking uniqueness of the summary pair.
+func putMatching(store *MemoryStore, matching Matching) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.matchings[matching.Id] = matching
+}
+
+func TestMemoryStore_CreateMatching_upsertsPair(t *testing.T) {
+	is := iss.New(t)
+	store := NewMemoryStore()
+	ctx := context.Background()
+	matching := Matching{
+		SummaryId:        makeObjectId(t, summaryId1),
+		MatchedSummaryId: makeObjectId(t, summaryId2),
+		MatchRate:        15,
+	}
+
+	first, err := store.CreateMatching(ctx, matching)
+	is.NoErr(err)
+	matching.MatchRate = 30
+	second, err := store.CreateMatching(ctx, matching)
+	is.NoErr(err)
+	is.Equal(first, second)
+
+	matchings, err := store.GetAllMatchings(ctx)
+	is.NoErr(err)
+	is.Equal(len(matchings), 1)
+	is.Equal(matchings[0].MatchRate, 30)
+
+	other, err := store.CreateMatching(ctx, Matching{SummaryId: makeObjectId(t, summaryId2), MatchedSummaryId: makeObjectId(t, summaryId1)})
+	is.NoErr(err)
+	moved := Matching{Id: other, SummaryId: matching.SummaryId, MatchedSummaryId: matching.MatchedSummaryId}
+	_, err = store.UpdateMatching(ctx, moved)
+	is.True(errors.Is(err, ErrConflict))
+}
+
+func TestMemoryStore_DedupeMatchings(t *testing.T) {
+	is := iss.New(t)
+	store := NewMemoryStore()
+	ctx := context.Background()
+	summary, matched := makeObjectId(t, summaryId1), makeObjectId(t, summaryId2)
+	now := time.Now()
+	newest := primitive.NewObjectID()
+	putMatching(store, Matching{Id: primitive.NewObjectID(), SummaryId: summary, MatchedSummaryId: matched, CreatedAt: now.Add(-time.Hour)})
+	putMatching(store, Matching{Id: newest, SummaryId: summary, MatchedSummaryId: matched, CreatedAt: now})
+	putMatching(store, Matching{Id: primitive.NewObjectID(), SummaryId: summary, MatchedSummaryId: matched, CreatedAt: now.Add(-2 * time.Hour)})
+	putMatching(store, Matching{Id: primitive.NewObjectID(), SummaryId: matched, MatchedSummaryId: summary, CreatedAt: now})
+
+	is.True(errors.Is(store.EnsureIndexes(ctx), ErrConflict))
+
+	result, err := store.DedupeMatchings(ctx, true)
+	is.NoErr(err)
+	is.Equal(result, DedupeResult{Pairs: 1, Removed: 2})
+	matchings, err := store.GetAllMatchings(ctx)
+	is.NoErr(err)
+	is.Equal(len(matchings), 4)
+
+	result, err = store.DedupeMatchings(ctx, false)
+	is.NoErr(err)
+	is.Equal(result, DedupeResult{Pairs: 1, Removed: 2})
+	kept, err := store.GetMatchingBySummaryId(ctx, summary)
+	is.NoErr(err)
+	is.Equal(kept.Id, newest)
+	is.NoErr(store.EnsureIndexes(ctx))
+}
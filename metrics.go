@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsCollectTimeout bounds database queries of gauges collected on scrape.
+const metricsCollectTimeout = 2 * time.Second
+
+// metricsDurationBuckets are upper bounds in seconds of latency histograms.
+var metricsDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metrics collects service metrics and exposes them in Prometheus text format.
+// Every Metrics has its own registry, so servers and tests don't share series.
+type Metrics struct {
+	registry      *prometheus.Registry
+	factory       promauto.Factory
+	httpRequests  *prometheus.CounterVec
+	httpDuration  *prometheus.HistogramVec
+	httpInFlight  prometheus.Gauge
+	storeDuration *prometheus.HistogramVec
+	storeErrors   *prometheus.CounterVec
+	handler       http.Handler
+}
+
+func NewMetrics() *Metrics {
+	registry := prometheus.NewRegistry()
+	factory := promauto.With(registry)
+	return &Metrics{
+		registry: registry,
+		factory:  factory,
+		httpRequests: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "matching_http_requests_total",
+			Help: "Number of HTTP requests by route pattern and status.",
+		}, []string{"method", "route", "status"}),
+		httpDuration: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "matching_http_request_duration_seconds",
+			Help:    "Latency of HTTP requests by route pattern.",
+			Buckets: metricsDurationBuckets,
+		}, []string{"method", "route"}),
+		httpInFlight: factory.NewGauge(prometheus.GaugeOpts{
+			Name: "matching_http_requests_in_flight",
+			Help: "Number of HTTP requests being served.",
+		}),
+		storeDuration: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "matching_store_operation_duration_seconds",
+			Help:    "Latency of store operations by method.",
+			Buckets: metricsDurationBuckets,
+		}, []string{"method"}),
+		storeErrors: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "matching_store_errors_total",
+			Help: "Number of failed store operations by method and error.",
+		}, []string{"method", "error"}),
+		handler: promhttp.HandlerFor(registry, promhttp.HandlerOpts{}),
+	}
+}
+
+// Middleware counts requests and measures their latency by chi route pattern,
+// so URLs with ids don't create separate series.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.httpInFlight.Inc()
+		defer m.httpInFlight.Dec()
+
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			// patterns of mounted routers are joined with double slash, e.g. /api/v1/matching//
+			route = strings.ReplaceAll(rctx.RoutePattern(), "//", "/")
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		m.httpRequests.WithLabelValues(r.Method, route, strconv.Itoa(status)).Inc()
+		m.httpDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
+
+// observeStore records latency and error of a store operation.
+func (m *Metrics) observeStore(method string, start time.Time, err error) {
+	m.storeDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		m.storeErrors.WithLabelValues(method, storeErrorKind(err)).Inc()
+	}
+}
+
+// AddRecomputeJobGauges exposes progress of the recompute job.
+func (m *Metrics) AddRecomputeJobGauges(job *RecomputeJob) {
+	for _, state := range []string{JobStateIdle, JobStateRunning, JobStateDone, JobStateCancelled, JobStateFailed} {
+		state := state
+		m.factory.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "matching_recompute_job_state",
+			Help:        "Current state of the recompute job, 1 for the current state.",
+			ConstLabels: prometheus.Labels{"state": state},
+		}, func() float64 {
+			if job.Progress().State == state {
+				return 1
+			}
+			return 0
+		})
+	}
+
+	counters := map[string]func(p RecomputeProgress) int64{
+		"summaries_total": func(p RecomputeProgress) int64 { return p.SummariesTotal },
+		"summaries_done":  func(p RecomputeProgress) int64 { return p.SummariesDone },
+		"pairs_scored":    func(p RecomputeProgress) int64 { return p.PairsScored },
+		"pairs_written":   func(p RecomputeProgress) int64 { return p.PairsWritten },
+		"errors":          func(p RecomputeProgress) int64 { return p.Errors },
+	}
+	for counter, value := range counters {
+		value := value
+		m.factory.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "matching_recompute_job_progress",
+			Help:        "Progress counters of the current or last recompute job run.",
+			ConstLabels: prometheus.Labels{"counter": counter},
+		}, func() float64 { return float64(value(job.Progress())) })
+	}
+}
+
+// AddCollectionGauge exposes estimated number of stored matchings.
+func (m *Metrics) AddCollectionGauge(store HealthStore) {
+	m.registry.MustRegister(collectionCollector{
+		store: store,
+		desc:  prometheus.NewDesc("matching_collection_documents", "Estimated number of documents of the matching collection.", nil, nil),
+	})
+}
+
+// Handler serves metrics in Prometheus text exposition format.
+// endpoint: GET /metrics
+func (m *Metrics) Handler(w http.ResponseWriter, r *http.Request) {
+	m.handler.ServeHTTP(w, r)
+}
+
+// collectionCollector counts matchings on scrape, the gauge is left out when the store fails.
+type collectionCollector struct {
+	store HealthStore
+	desc  *prometheus.Desc
+}
+
+func (c collectionCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c collectionCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), metricsCollectTimeout)
+	defer cancel()
+	count, err := c.store.CountMatchings(ctx)
+	if err != nil {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(count))
+}
+
+// storeErrorKind returns low cardinality label of the store error.
+func storeErrorKind(err error) string {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return "not_found"
+	case errors.Is(err, ErrConflict):
+		return "conflict"
+	case errors.Is(err, ErrInvalid):
+		return "invalid"
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	}
+	return "error"
+}
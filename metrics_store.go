@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// metricsStore measures latency and errors of every DataStore method and logs the operations.
+type metricsStore struct {
+	store   DataStore
+	metrics *Metrics
+}
+
+// observe records metrics of the store operation and logs it with request fields of ctx.
+func (m *metricsStore) observe(ctx context.Context, method string, start time.Time, err error) {
+	m.metrics.observeStore(method, start, err)
+	log := LoggerFromContext(ctx)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		log.Warn("store operation failed", "method", method, "latency", time.Since(start), "error", err)
+		return
+	}
+	log.Debug("store operation", "method", method, "latency", time.Since(start))
+}
+
+// NewMetricsStore wraps store, so its operations are reported by metrics.
+func NewMetricsStore(store DataStore, metrics *Metrics) DataStore {
+	return &metricsStore{store: store, metrics: metrics}
+}
+
+func (m *metricsStore) GetMatching(ctx context.Context, id primitive.ObjectID) (matching Matching, err error) {
+	defer func(start time.Time) { m.observe(ctx, "GetMatching", start, err) }(time.Now())
+	return m.store.GetMatching(ctx, id)
+}
+
+func (m *metricsStore) GetMatchingBySummaryId(ctx context.Context, summaryID primitive.ObjectID) (matching Matching, err error) {
+	defer func(start time.Time) { m.observe(ctx, "GetMatchingBySummaryId", start, err) }(time.Now())
+	return m.store.GetMatchingBySummaryId(ctx, summaryID)
+}
+
+func (m *metricsStore) GetMatchingsBySummaryId(ctx context.Context, summaryID primitive.ObjectID, query SummaryMatchingsQuery) (matchings []*Matching, err error) {
+	defer func(start time.Time) { m.observe(ctx, "GetMatchingsBySummaryId", start, err) }(time.Now())
+	return m.store.GetMatchingsBySummaryId(ctx, summaryID, query)
+}
+
+func (m *metricsStore) GetMutualMatchings(ctx context.Context, summaryID primitive.ObjectID, query MutualMatchingsQuery) (matchings []MutualMatching, err error) {
+	defer func(start time.Time) { m.observe(ctx, "GetMutualMatchings", start, err) }(time.Now())
+	return m.store.GetMutualMatchings(ctx, summaryID, query)
+}
+
+func (m *metricsStore) GetAllMatchings(ctx context.Context) (matchings []*Matching, err error) {
+	defer func(start time.Time) { m.observe(ctx, "GetAllMatchings", start, err) }(time.Now())
+	return m.store.GetAllMatchings(ctx)
+}
+
+func (m *metricsStore) IterateMatchingsBySummary(ctx context.Context) (iterator MatchingsIterator, err error) {
+	defer func(start time.Time) { m.observe(ctx, "IterateMatchingsBySummary", start, err) }(time.Now())
+	return m.store.IterateMatchingsBySummary(ctx)
+}
+
+func (m *metricsStore) GetMatchingsBetween(ctx context.Context, summaryIDs, matchedSummaryIDs []primitive.ObjectID) (matchings []*Matching, err error) {
+	defer func(start time.Time) { m.observe(ctx, "GetMatchingsBetween", start, err) }(time.Now())
+	return m.store.GetMatchingsBetween(ctx, summaryIDs, matchedSummaryIDs)
+}
+
+func (m *metricsStore) FindMatchings(ctx context.Context, query MatchingsQuery) (page MatchingsPage, err error) {
+	defer func(start time.Time) { m.observe(ctx, "FindMatchings", start, err) }(time.Now())
+	return m.store.FindMatchings(ctx, query)
+}
+
+func (m *metricsStore) CreateMatching(ctx context.Context, matching Matching) (id primitive.ObjectID, err error) {
+	defer func(start time.Time) { m.observe(ctx, "CreateMatching", start, err) }(time.Now())
+	return m.store.CreateMatching(ctx, matching)
+}
+
+func (m *metricsStore) UpdateMatching(ctx context.Context, matching Matching) (count int64, err error) {
+	defer func(start time.Time) { m.observe(ctx, "UpdateMatching", start, err) }(time.Now())
+	return m.store.UpdateMatching(ctx, matching)
+}
+
+func (m *metricsStore) DeleteMatching(ctx context.Context, id primitive.ObjectID) (err error) {
+	defer func(start time.Time) { m.observe(ctx, "DeleteMatching", start, err) }(time.Now())
+	return m.store.DeleteMatching(ctx, id)
+}
+
+func (m *metricsStore) BulkWriteMatchings(ctx context.Context, matchings []Matching) (results []BulkItemResult, err error) {
+	defer func(start time.Time) { m.observe(ctx, "BulkWriteMatchings", start, err) }(time.Now())
+	return m.store.BulkWriteMatchings(ctx, matchings)
+}
+
+func (m *metricsStore) UpsertMatchingsByPair(ctx context.Context, matchings []Matching) (results []BulkItemResult, err error) {
+	defer func(start time.Time) { m.observe(ctx, "UpsertMatchingsByPair", start, err) }(time.Now())
+	return m.store.UpsertMatchingsByPair(ctx, matchings)
+}
+
+func (m *metricsStore) GetSummary(ctx context.Context, id primitive.ObjectID) (summary SummaryDocument, err error) {
+	defer func(start time.Time) { m.observe(ctx, "GetSummary", start, err) }(time.Now())
+	return m.store.GetSummary(ctx, id)
+}
+
+func (m *metricsStore) GetSummariesAfter(ctx context.Context, afterID primitive.ObjectID, limit int) (summaries []SummaryDocument, err error) {
+	defer func(start time.Time) { m.observe(ctx, "GetSummariesAfter", start, err) }(time.Now())
+	return m.store.GetSummariesAfter(ctx, afterID, limit)
+}
+
+func (m *metricsStore) CountSummariesAfter(ctx context.Context, afterID primitive.ObjectID) (count int64, err error) {
+	defer func(start time.Time) { m.observe(ctx, "CountSummariesAfter", start, err) }(time.Now())
+	return m.store.CountSummariesAfter(ctx, afterID)
+}
+
+func (m *metricsStore) ExistingSummaryIds(ctx context.Context, ids []primitive.ObjectID) (existing map[primitive.ObjectID]bool, err error) {
+	defer func(start time.Time) { m.observe(ctx, "ExistingSummaryIds", start, err) }(time.Now())
+	return m.store.ExistingSummaryIds(ctx, ids)
+}
+
+func (m *metricsStore) GetCheckpoint(ctx context.Context, job string) (checkpoint JobCheckpoint, err error) {
+	defer func(start time.Time) { m.observe(ctx, "GetCheckpoint", start, err) }(time.Now())
+	return m.store.GetCheckpoint(ctx, job)
+}
+
+func (m *metricsStore) SaveCheckpoint(ctx context.Context, checkpoint JobCheckpoint) (err error) {
+	defer func(start time.Time) { m.observe(ctx, "SaveCheckpoint", start, err) }(time.Now())
+	return m.store.SaveCheckpoint(ctx, checkpoint)
+}
+
+func (m *metricsStore) DeleteCheckpoint(ctx context.Context, job string) (err error) {
+	defer func(start time.Time) { m.observe(ctx, "DeleteCheckpoint", start, err) }(time.Now())
+	return m.store.DeleteCheckpoint(ctx, job)
+}
+
+func (m *metricsStore) EnsureIndexes(ctx context.Context) (err error) {
+	defer func(start time.Time) { m.observe(ctx, "EnsureIndexes", start, err) }(time.Now())
+	return m.store.EnsureIndexes(ctx)
+}
+
+func (m *metricsStore) DedupeMatchings(ctx context.Context, dryRun bool) (result DedupeResult, err error) {
+	defer func(start time.Time) { m.observe(ctx, "DedupeMatchings", start, err) }(time.Now())
+	return m.store.DedupeMatchings(ctx, dryRun)
+}
+
+func (m *metricsStore) VerifyMatchings(ctx context.Context, remove bool) (report VerifyReport, err error) {
+	defer func(start time.Time) { m.observe(ctx, "VerifyMatchings", start, err) }(time.Now())
+	return m.store.VerifyMatchings(ctx, remove)
+}
+
+func (m *metricsStore) GetSummaryIdsByProfile(ctx context.Context, profileID primitive.ObjectID) (ids []primitive.ObjectID, err error) {
+	defer func(start time.Time) { m.observe(ctx, "GetSummaryIdsByProfile", start, err) }(time.Now())
+	return m.store.GetSummaryIdsByProfile(ctx, profileID)
+}
+
+func (m *metricsStore) DeleteMatchingsBySummaries(ctx context.Context, summaryIDs []primitive.ObjectID) (count int64, err error) {
+	defer func(start time.Time) { m.observe(ctx, "DeleteMatchingsBySummaries", start, err) }(time.Now())
+	return m.store.DeleteMatchingsBySummaries(ctx, summaryIDs)
+}
+
+func (m *metricsStore) SaveErasureAudit(ctx context.Context, audit ErasureAudit) (err error) {
+	defer func(start time.Time) { m.observe(ctx, "SaveErasureAudit", start, err) }(time.Now())
+	return m.store.SaveErasureAudit(ctx, audit)
+}
+
+func (m *metricsStore) Ping(ctx context.Context) (err error) {
+	defer func(start time.Time) { m.observe(ctx, "Ping", start, err) }(time.Now())
+	return m.store.Ping(ctx)
+}
+
+func (m *metricsStore) MissingIndexes(ctx context.Context) (names []string, err error) {
+	defer func(start time.Time) { m.observe(ctx, "MissingIndexes", start, err) }(time.Now())
+	return m.store.MissingIndexes(ctx)
+}
+
+func (m *metricsStore) CountMatchings(ctx context.Context) (count int64, err error) {
+	defer func(start time.Time) { m.observe(ctx, "CountMatchings", start, err) }(time.Now())
+	return m.store.CountMatchings(ctx)
+}
+
+func (m *metricsStore) GetAPIKey(ctx context.Context, id primitive.ObjectID) (key APIKey, err error) {
+	defer func(start time.Time) { m.observe(ctx, "GetAPIKey", start, err) }(time.Now())
+	return m.store.GetAPIKey(ctx, id)
+}
+
+func (m *metricsStore) GetAllAPIKeys(ctx context.Context) (keys []APIKey, err error) {
+	defer func(start time.Time) { m.observe(ctx, "GetAllAPIKeys", start, err) }(time.Now())
+	return m.store.GetAllAPIKeys(ctx)
+}
+
+func (m *metricsStore) SaveAPIKey(ctx context.Context, key APIKey) (err error) {
+	defer func(start time.Time) { m.observe(ctx, "SaveAPIKey", start, err) }(time.Now())
+	return m.store.SaveAPIKey(ctx, key)
+}
+
+func (m *metricsStore) TouchAPIKey(ctx context.Context, id primitive.ObjectID, usedAt time.Time) (err error) {
+	defer func(start time.Time) { m.observe(ctx, "TouchAPIKey", start, err) }(time.Now())
+	return m.store.TouchAPIKey(ctx, id, usedAt)
+}
+
+// Namespace returns the store of the scorer version namespace reported by the same metrics.
+func (m *metricsStore) Namespace(version string) (DataStore, error) {
+	store, err := m.store.Namespace(version)
+	if err != nil {
+		return nil, err
+	}
+	return &metricsStore{store: store, metrics: m.metrics}, nil
+}
+
+func (m *metricsStore) Namespaces(ctx context.Context) (versions []string, err error) {
+	defer func(start time.Time) { m.observe(ctx, "Namespaces", start, err) }(time.Now())
+	return m.store.Namespaces(ctx)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"github.com/go-chi/chi"
+	iss "github.com/matryer/is"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	is := iss.New(t)
+	metrics := NewMetrics()
+	store := NewMetricsStore(NewMemoryStore(), metrics)
+	metrics.AddCollectionGauge(store)
+	job, _, _ := newTestRecomputeJob(t, 2)
+	metrics.AddRecomputeJobGauges(job)
+	s := NewServer("test", store)
+	router := chi.NewRouter()
+	router.Use(metrics.Middleware)
+	router.Mount("/api/v1/matching", s.Router)
+
+	for _, id := range []string{primitive.NewObjectID().Hex(), primitive.NewObjectID().Hex(), ""} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/matching/"+id, nil))
+	}
+	_, err := store.CreateMatching(context.Background(), Matching{SummaryId: primitive.NewObjectID(), MatchedSummaryId: primitive.NewObjectID()})
+	is.NoErr(err)
+
+	w := httptest.NewRecorder()
+	metrics.Handler(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	is.Equal(w.Code, http.StatusOK)
+	text := w.Body.String()
+	for _, line := range []string{
+		`matching_http_requests_total{method="GET",route="/api/v1/matching/{id}",status="404"} 2`,
+		`matching_http_requests_total{method="GET",route="/api/v1/matching/",status="200"} 1`,
+		`matching_http_request_duration_seconds_count{method="GET",route="/api/v1/matching/{id}"} 2`,
+		`matching_http_request_duration_seconds_bucket{method="GET",route="/api/v1/matching/",le="+Inf"} 1`,
+		`matching_http_requests_in_flight 0`,
+		`matching_store_operation_duration_seconds_count{method="GetMatching"} 2`,
+		`matching_store_errors_total{error="not_found",method="GetMatching"} 2`,
+		`matching_collection_documents 1`,
+		`matching_recompute_job_state{state="idle"} 1`,
+		`matching_recompute_job_state{state="running"} 0`,
+		`matching_recompute_job_progress{counter="summaries_done"} 0`,
+		`# TYPE matching_store_operation_duration_seconds histogram`,
+	} {
+		is.True(strings.Contains(text, line+"\n")) // metric line missing
+	}
+}
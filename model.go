@@ -11,4 +11,33 @@ type Matching struct {
 	MatchedSummaryId primitive.ObjectID `json:"matchedSummaryId" bson:"matchedSummaryId"`
 	MatchRate        int                `json:"matchRate" bson:"matchRate"`
 	CreatedAt        time.Time          `json:"createdAt,omitempty" bson:"createdAt,omitempty"`
+	// ScorerVersion is the version of the scorer which computed MatchRate.
+	ScorerVersion string `json:"scorerVersion,omitempty" bson:"scorerVersion,omitempty"`
+	// Breakdown explains MatchRate, it is nil when the matching was written without it.
+	Breakdown *ScoreBreakdown `json:"breakdown,omitempty" bson:"breakdown,omitempty"`
+}
+
+// ScoreBreakdown lists weighted components of the matchRate and the scorer which computed them.
+type ScoreBreakdown struct {
+	Scorer     string           `json:"scorer" bson:"scorer"`
+	Version    string           `json:"version,omitempty" bson:"version,omitempty"`
+	Components []ScoreComponent `json:"components" bson:"components"`
+}
+
+// ScoreComponent is the score of a single named component, e.g. overlap of skills.
+type ScoreComponent struct {
+	Name string `json:"name" bson:"name"`
+	// Score is in the range from 0 to 100.
+	Score  float64 `json:"score" bson:"score"`
+	Weight float64 `json:"weight" bson:"weight"`
+}
+
+// SummaryMatchingsQuery narrows down the ranked list of matchings of a summary.
+type SummaryMatchingsQuery struct {
+	// Limit is the max number of returned matchings, 0 means no limit.
+	Limit int
+	// MinRate excludes matchings with lower matchRate.
+	MinRate int
+	// Exclude lists matched summaries which should not be returned.
+	Exclude []primitive.ObjectID
 }
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Methods combining matchRate of both directions into the score of a mutual matching.
+const (
+	// CombineMin scores the pair by the lower of both rates.
+	CombineMin = "min"
+	// CombineHarmonic scores the pair by the harmonic mean of both rates.
+	CombineHarmonic = "harmonic"
+	// CombineProduct scores the pair by the product of both rates scaled back to 0-100.
+	CombineProduct = "product"
+)
+
+// MutualMatching is a pair of summaries matched in both directions.
+type MutualMatching struct {
+	SummaryId         primitive.ObjectID `json:"summaryId" bson:"summaryId"`
+	MatchedSummaryId  primitive.ObjectID `json:"matchedSummaryId" bson:"matchedSummaryId"`
+	MatchRate         int                `json:"matchRate" bson:"matchRate"`
+	ReverseMatchRate  int                `json:"reverseMatchRate" bson:"reverseMatchRate"`
+	Score             float64            `json:"score" bson:"score"`
+	MatchingId        primitive.ObjectID `json:"matchingId" bson:"matchingId"`
+	ReverseMatchingId primitive.ObjectID `json:"reverseMatchingId" bson:"reverseMatchingId"`
+}
+
+// MutualMatchingsQuery narrows down the ranked list of mutual matchings of a summary.
+type MutualMatchingsQuery struct {
+	// Limit is the max number of returned matchings, 0 means no limit.
+	Limit int
+	// MinRate excludes pairs with lower matchRate in any direction.
+	MinRate int
+	// Combine is the method of the score, one of CombineMin, CombineHarmonic or CombineProduct.
+	Combine string
+}
+
+// combineRates returns the score of rates a and b combined by method.
+func combineRates(method string, a, b int) float64 {
+	x, y := float64(a), float64(b)
+	switch method {
+	case CombineHarmonic:
+		if x+y == 0 {
+			return 0
+		}
+		return 2 * x * y / (x + y)
+	case CombineProduct:
+		return x * y / 100
+	default:
+		if x < y {
+			return x
+		}
+		return y
+	}
+}
+
+// sortMutualMatchings orders matchings by score in descending order, ties by matchedSummaryId.
+func sortMutualMatchings(matchings []MutualMatching) {
+	sort.SliceStable(matchings, func(i, j int) bool {
+		if matchings[i].Score != matchings[j].Score {
+			return matchings[i].Score > matchings[j].Score
+		}
+		return bytes.Compare(matchings[i].MatchedSummaryId[:], matchings[j].MatchedSummaryId[:]) < 0
+	})
+}
+
+func parseMutualMatchingsQuery(r *http.Request) (MutualMatchingsQuery, error) {
+	query := MutualMatchingsQuery{Combine: r.URL.Query().Get("combine")}
+	var err error
+	if query.Limit, err = URLQueryInt(r, "limit", 0); err != nil || query.Limit < 0 {
+		return query, errors.New("invalid request data limit")
+	}
+	if query.MinRate, err = URLQueryInt(r, "minRate", 0); err != nil {
+		return query, err
+	}
+	switch query.Combine {
+	case "":
+		query.Combine = CombineMin
+	case CombineMin, CombineHarmonic, CombineProduct:
+	default:
+		return query, errors.New("invalid request data combine")
+	}
+	return query, nil
+}
+
+func (s *Server) getMutualMatchingsHandler(w http.ResponseWriter, r *http.Request) {
+	summaryID, err := URLParamObjectID(r, "summaryId")
+	if err != nil {
+		RespondError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	query, err := parseMutualMatchingsQuery(r)
+	if err != nil {
+		RespondError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := s.authorizeSummary(r.Context(), summaryID); err != nil {
+		respondStoreError(w, r, err)
+		return
+	}
+
+	store, err := s.readStore(w, r, summaryID)
+	if err != nil {
+		respondStoreError(w, r, err)
+		return
+	}
+	matchings, err := store.GetMutualMatchings(r.Context(), summaryID, query)
+	if err != nil {
+		respondStoreError(w, r, err)
+		return
+	}
+
+	Respond(w, r, http.StatusOK, matchings)
+}
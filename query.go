@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// Paging limits of the matching list.
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+// Sort orders supported by MatchingsQuery. Minus prefix means descending order.
+// Matchings with equal sort value are ordered by Id.
+var matchingSortFields = map[string]string{
+	"":           "_id",
+	"matchRate":  "matchRate",
+	"-matchRate": "matchRate",
+	"createdAt":  "createdAt",
+	"-createdAt": "createdAt",
+}
+
+// MatchingsQuery filters, sorts and pages the list of all matchings.
+// Zero values of the fields mean no filtering.
+type MatchingsQuery struct {
+	SummaryId        primitive.ObjectID
+	MatchedSummaryId primitive.ObjectID
+	MinRate          *int
+	MaxRate          *int
+	// CreatedFrom is inclusive, CreatedTo exclusive bound of createdAt.
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	// Sort is one of matchRate, -matchRate, createdAt, -createdAt, default order is by Id.
+	Sort string
+	// After is an opaque cursor returned as MatchingsPage.Next of the previous page.
+	After string
+	Limit int
+}
+
+// MatchingsPage is a single page of matchings returned by MatchingsQuery.
+type MatchingsPage struct {
+	Matchings []*Matching
+	// Total is the number of matchings passing the query filters on all pages.
+	Total int64
+	// Next is the cursor of the next page, empty on the last page.
+	Next string
+}
+
+// matches reports whether matching passes the query filters.
+func (q MatchingsQuery) matches(m *Matching) bool {
+	switch {
+	case q.SummaryId != primitive.NilObjectID && m.SummaryId != q.SummaryId:
+		return false
+	case q.MatchedSummaryId != primitive.NilObjectID && m.MatchedSummaryId != q.MatchedSummaryId:
+		return false
+	case q.MinRate != nil && m.MatchRate < *q.MinRate:
+		return false
+	case q.MaxRate != nil && m.MatchRate > *q.MaxRate:
+		return false
+	case !q.CreatedFrom.IsZero() && m.CreatedAt.Before(q.CreatedFrom):
+		return false
+	case !q.CreatedTo.IsZero() && !m.CreatedAt.Before(q.CreatedTo):
+		return false
+	}
+	return true
+}
+
+// sortField returns name of the sorted field and whether order is descending.
+func (q MatchingsQuery) sortField() (string, bool, error) {
+	field, ok := matchingSortFields[q.Sort]
+	if !ok {
+		return "", false, fmt.Errorf("%w: unsupported sort %q", ErrInvalid, q.Sort)
+	}
+	return field, len(q.Sort) > 0 && q.Sort[0] == '-', nil
+}
+
+// pageLimit returns the page size limited to maxPageLimit.
+func (q MatchingsQuery) pageLimit() int {
+	if q.Limit <= 0 {
+		return defaultPageLimit
+	}
+	if q.Limit > maxPageLimit {
+		return maxPageLimit
+	}
+	return q.Limit
+}
+
+// pageCursor is the position of the last matching of the page in the sorted list.
+type pageCursor struct {
+	Sort      string             `json:"s"`
+	Rate      int                `json:"r"`
+	CreatedAt time.Time          `json:"c"`
+	Id        primitive.ObjectID `json:"i"`
+}
+
+func encodePageCursor(sort string, last *Matching) string {
+	cursor := pageCursor{Sort: sort, Rate: last.MatchRate, CreatedAt: last.CreatedAt, Id: last.Id}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodePageCursor decodes cursor token created for the same sort order.
+func decodePageCursor(token string, sort string) (pageCursor, error) {
+	cursor := pageCursor{}
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return cursor, fmt.Errorf("%w: malformed cursor", ErrInvalid)
+	}
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return cursor, fmt.Errorf("%w: malformed cursor", ErrInvalid)
+	}
+	if cursor.Sort != sort {
+		return cursor, fmt.Errorf("%w: cursor was created for a different sort", ErrInvalid)
+	}
+	return cursor, nil
+}
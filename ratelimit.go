@@ -0,0 +1,204 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Rate limit headers, see draft-ietf-httpapi-ratelimit-headers.
+const (
+	headerRateLimitLimit     = "RateLimit-Limit"
+	headerRateLimitRemaining = "RateLimit-Remaining"
+	headerRateLimitReset     = "RateLimit-Reset"
+	headerRetryAfter         = "Retry-After"
+)
+
+// rateLimiterPruneSize is the number of buckets above which refilled buckets are dropped.
+const rateLimiterPruneSize = 10000
+
+// Route groups with separate rate limits.
+const (
+	rateLimitGroupRead  = "read"
+	rateLimitGroupWrite = "write"
+	rateLimitGroupAdmin = "admin"
+	// rateLimitGroupAuth counts failed authentications of an IP address.
+	rateLimitGroupAuth = "auth"
+)
+
+// RateLimits are max requests per minute of a single client per route group, 0 means no limit.
+// Client is identified by API key, token subject or IP address.
+type RateLimits struct {
+	Read  int `yaml:"read"`
+	Write int `yaml:"write"`
+	Admin int `yaml:"admin"`
+}
+
+// Enabled reports whether any route group is limited.
+func (l RateLimits) Enabled() bool {
+	return l.Read > 0 || l.Write > 0 || l.Admin > 0
+}
+
+// Max returns the highest limit of route groups, it also limits failed authentications of an IP address.
+func (l RateLimits) Max() int {
+	max := l.Read
+	if l.Write > max {
+		max = l.Write
+	}
+	if l.Admin > max {
+		max = l.Admin
+	}
+	return max
+}
+
+// tokenBucket allows bursts up to its capacity and refills at a constant rate.
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// rateLimitResult is the state of the bucket after a request.
+type rateLimitResult struct {
+	allowed   bool
+	limit     int
+	remaining int
+	// reset is the time until the bucket is full again
+	reset time.Duration
+	// retryAfter is the time until next token when request is not allowed
+	retryAfter time.Duration
+}
+
+// rateLimiter keeps a token bucket per key, e.g. per API key.
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	now     func() time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[string]*tokenBucket), now: time.Now}
+}
+
+// allow takes a token from the bucket of the key refilled with perMinute tokens per minute,
+// burst is one minute worth of requests.
+func (l *rateLimiter) allow(key string, perMinute int) rateLimitResult {
+	return l.take(key, perMinute, 1)
+}
+
+// peek reports the state of the bucket of the key without taking a token.
+func (l *rateLimiter) peek(key string, perMinute int) rateLimitResult {
+	return l.take(key, perMinute, 0)
+}
+
+func (l *rateLimiter) take(key string, perMinute int, cost float64) rateLimitResult {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	capacity := float64(perMinute)
+	bucket, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= rateLimiterPruneSize {
+			l.prune(now)
+		}
+		bucket = &tokenBucket{tokens: capacity, updated: now}
+		l.buckets[key] = bucket
+	}
+
+	rate := capacity / float64(time.Minute)
+	bucket.tokens = math.Min(capacity, bucket.tokens+float64(now.Sub(bucket.updated))*rate)
+	bucket.updated = now
+	result := rateLimitResult{allowed: bucket.tokens >= 1, limit: perMinute}
+	if result.allowed {
+		bucket.tokens -= cost
+	} else {
+		result.retryAfter = time.Duration(math.Ceil((1 - bucket.tokens) / rate))
+	}
+	result.remaining = int(bucket.tokens)
+	result.reset = time.Duration(math.Ceil((capacity - bucket.tokens) / rate))
+	return result
+}
+
+// prune drops buckets not used for a minute, they are refilled and equal to new buckets.
+// Caller must hold the lock.
+func (l *rateLimiter) prune(now time.Time) {
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.updated) >= time.Minute {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// respondRateLimit sets rate limit headers and responds with 429 when the request is not allowed.
+// It returns false when the response has been written.
+func respondRateLimit(w http.ResponseWriter, r *http.Request, result rateLimitResult) bool {
+	w.Header().Set(headerRateLimitLimit, strconv.Itoa(result.limit))
+	w.Header().Set(headerRateLimitRemaining, strconv.Itoa(result.remaining))
+	w.Header().Set(headerRateLimitReset, strconv.Itoa(ceilSeconds(result.reset)))
+	if result.allowed {
+		return true
+	}
+
+	retryAfter := ceilSeconds(result.retryAfter)
+	w.Header().Set(headerRetryAfter, strconv.Itoa(retryAfter))
+	RespondError(w, r, http.StatusTooManyRequests, "rate limit exceeded, retry in ", retryAfter, "s")
+	return false
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// rateLimit limits requests of each client of the route group to perMinute requests per minute.
+// Client is identified by the subject of authenticated caller, API key or token, or by the IP address.
+func (s *Server) rateLimit(group string, perMinute int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if perMinute <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if respondRateLimit(w, r, s.limiter.allow(group+"|"+rateLimitClient(r), perMinute)) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// checkAuthFailures responds with 429 when the IP address of the request used up its failed
+// authentications, so invalid credentials are rejected before they are looked up.
+// It returns false when the response has been written.
+func (s *Server) checkAuthFailures(w http.ResponseWriter, r *http.Request) bool {
+	perMinute := s.rateLimits.Max()
+	if perMinute <= 0 {
+		return true
+	}
+	result := s.limiter.peek(rateLimitGroupAuth+"|"+rateLimitIP(r), perMinute)
+	return result.allowed || respondRateLimit(w, r, result)
+}
+
+// recordAuthFailure takes a token from the failed authentications bucket of the IP address.
+func (s *Server) recordAuthFailure(r *http.Request) {
+	if perMinute := s.rateLimits.Max(); perMinute > 0 {
+		s.limiter.allow(rateLimitGroupAuth+"|"+rateLimitIP(r), perMinute)
+	}
+}
+
+// rateLimitClient returns identity of the caller.
+func rateLimitClient(r *http.Request) string {
+	if claims, ok := ClaimsFromContext(r.Context()); ok && claims.Subject != "" {
+		return "sub:" + claims.Subject
+	}
+	return rateLimitIP(r)
+}
+
+// rateLimitIP returns the client IP, RemoteAddr is set to client IP by middleware.RealIP.
+func rateLimitIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
@@ -2,9 +2,15 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"io"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -16,10 +22,12 @@ var EmptyFilter = bson.M{}
 type Repo struct {
 	mngClient *mongo.Client
 	DbName    string
+	// namespace is the scorer version of matchings kept in own collection, empty for the default one.
+	namespace string
 }
 
 func NewRepo(mngClient *mongo.Client, dbName string) *Repo {
-	return &Repo{mngClient, dbName}
+	return &Repo{mngClient: mngClient, DbName: dbName}
 }
 
 func (r *Repo) GetMatching(ctx context.Context, id primitive.ObjectID) (Matching, error) {
@@ -27,33 +35,277 @@ func (r *Repo) GetMatching(ctx context.Context, id primitive.ObjectID) (Matching
 	return r.readMatching(ctx, filter)
 }
 
-// getMatchingBySummaryId returns a list of matchings for passed summaryId.
+// GetMatchingBySummaryId returns the first matching found for passed summaryId.
+// Use GetMatchingsBySummaryId to get all matchings of the summary.
 func (r *Repo) GetMatchingBySummaryId(ctx context.Context, summaryID primitive.ObjectID) (Matching, error) {
 	filter := bson.M{"summaryId": summaryID}
 	return r.readMatching(ctx, filter)
 }
 
+// GetMatchingsBySummaryId returns matchings for passed summaryId ranked by matchRate
+// in descending order and narrowed down by passed query.
+func (r *Repo) GetMatchingsBySummaryId(ctx context.Context, summaryID primitive.ObjectID, query SummaryMatchingsQuery) ([]*Matching, error) {
+	filter := bson.M{"summaryId": summaryID}
+	if query.MinRate > 0 {
+		filter["matchRate"] = bson.M{"$gte": query.MinRate}
+	}
+	if len(query.Exclude) > 0 {
+		filter["matchedSummaryId"] = bson.M{"$nin": query.Exclude}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "matchRate", Value: -1}, {Key: "_id", Value: 1}})
+	if query.Limit > 0 {
+		opts.SetLimit(int64(query.Limit))
+	}
+	return r.readMatchings(ctx, filter, opts)
+}
+
+// GetMutualMatchings returns pairs of summaryId matched in both directions with matchRate
+// of at least query.MinRate. Reverse matching is joined by $lookup of matchedSummaryId on summaryId
+// with equal matchedSummaryId, both keys of the summary pair index. It needs MongoDB 5.0 or newer.
+func (r *Repo) GetMutualMatchings(ctx context.Context, summaryID primitive.ObjectID, query MutualMatchingsQuery) ([]MutualMatching, error) {
+	minRate := bson.D{{Key: "$gte", Value: query.MinRate}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{{Key: "summaryId", Value: summaryID}, {Key: "matchRate", Value: minRate}}}},
+		{{Key: "$lookup", Value: bson.D{
+			{Key: "from", Value: r.getMatchingCollection().Name()},
+			{Key: "localField", Value: "matchedSummaryId"},
+			{Key: "foreignField", Value: "summaryId"},
+			{Key: "pipeline", Value: mongo.Pipeline{
+				{{Key: "$match", Value: bson.D{{Key: "matchedSummaryId", Value: summaryID}, {Key: "matchRate", Value: minRate}}}},
+				{{Key: "$limit", Value: 1}},
+			}},
+			{Key: "as", Value: "reverse"},
+		}}},
+		{{Key: "$match", Value: bson.D{{Key: "reverse", Value: bson.D{{Key: "$ne", Value: bson.A{}}}}}}},
+		{{Key: "$unwind", Value: "$reverse"}},
+		{{Key: "$project", Value: bson.D{
+			{Key: "_id", Value: 0},
+			{Key: "summaryId", Value: 1},
+			{Key: "matchedSummaryId", Value: 1},
+			{Key: "matchRate", Value: 1},
+			{Key: "reverseMatchRate", Value: "$reverse.matchRate"},
+			{Key: "score", Value: mutualScoreExpr(query.Combine, "$matchRate", "$reverse.matchRate")},
+			{Key: "matchingId", Value: "$_id"},
+			{Key: "reverseMatchingId", Value: "$reverse._id"},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "score", Value: -1}, {Key: "matchedSummaryId", Value: 1}}}},
+	}
+	if query.Limit > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$limit", Value: query.Limit}})
+	}
+
+	cursor, err := r.getMatchingCollection().Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, repoError(err)
+	}
+	defer cursor.Close(ctx)
+
+	result := make([]MutualMatching, 0)
+	if err := cursor.All(ctx, &result); err != nil {
+		return nil, repoError(err)
+	}
+	return result, nil
+}
+
+// mutualScoreExpr is the aggregation expression of combineRates.
+func mutualScoreExpr(method string, a, b string) interface{} {
+	x := bson.D{{Key: "$toDouble", Value: a}}
+	y := bson.D{{Key: "$toDouble", Value: b}}
+	switch method {
+	case CombineHarmonic:
+		sum := bson.D{{Key: "$add", Value: bson.A{x, y}}}
+		return bson.D{{Key: "$cond", Value: bson.A{
+			bson.D{{Key: "$eq", Value: bson.A{sum, 0}}},
+			0.0,
+			bson.D{{Key: "$divide", Value: bson.A{bson.D{{Key: "$multiply", Value: bson.A{2, x, y}}}, sum}}},
+		}}}
+	case CombineProduct:
+		return bson.D{{Key: "$divide", Value: bson.A{bson.D{{Key: "$multiply", Value: bson.A{x, y}}}, 100}}}
+	default:
+		return bson.D{{Key: "$min", Value: bson.A{x, y}}}
+	}
+}
+
 // GetAllMatchings retrieves a list of matchings from the database.
 func (r *Repo) GetAllMatchings(ctx context.Context) ([]*Matching, error) {
 	return r.readMatchings(ctx, EmptyFilter)
 }
 
-func (r Repo) readMatching(ctx context.Context, filter interface{}) (Matching, error) {
-	cursor, _ := r.getMatchingCollection().Find(ctx, filter)
+// IterateMatchingsBySummary streams matchings ordered by the summary pair index.
+func (r *Repo) IterateMatchingsBySummary(ctx context.Context) (MatchingsIterator, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "summaryId", Value: 1}, {Key: "matchedSummaryId", Value: 1}})
+	cursor, err := r.getMatchingCollection().Find(ctx, EmptyFilter, opts)
+	if err != nil {
+		return nil, repoError(err)
+	}
+	return &cursorMatchingsIterator{cursor: cursor}, nil
+}
 
-	matching := Matching{}
-	if cursor.Next(ctx) {
-		err := cursor.Decode(&matching)
+// cursorMatchingsIterator groups matchings of the cursor sorted by summaryId.
+type cursorMatchingsIterator struct {
+	cursor *mongo.Cursor
+	// next is the first matching of the next summary read ahead
+	next *Matching
+}
+
+func (it *cursorMatchingsIterator) Next(ctx context.Context) (SummaryMatchings, error) {
+	group := SummaryMatchings{}
+	if it.next != nil {
+		group = SummaryMatchings{SummaryId: it.next.SummaryId, Matchings: []*Matching{it.next}}
+		it.next = nil
+	}
+	for it.cursor.Next(ctx) {
+		matching := Matching{}
+		if err := it.cursor.Decode(&matching); err != nil {
+			return group, err
+		}
+		if len(group.Matchings) > 0 && matching.SummaryId != group.SummaryId {
+			it.next = &matching
+			return group, nil
+		}
+		group.SummaryId = matching.SummaryId
+		group.Matchings = append(group.Matchings, &matching)
+	}
+	if err := it.cursor.Err(); err != nil {
+		return group, repoError(err)
+	}
+	if len(group.Matchings) == 0 {
+		return group, io.EOF
+	}
+	return group, nil
+}
+
+func (it *cursorMatchingsIterator) Close(ctx context.Context) error {
+	return it.cursor.Close(ctx)
+}
+
+// GetMatchingsBetween returns matchings of any of summaryIDs with any of matchedSummaryIDs.
+func (r *Repo) GetMatchingsBetween(ctx context.Context, summaryIDs, matchedSummaryIDs []primitive.ObjectID) ([]*Matching, error) {
+	filter := bson.M{
+		"summaryId":        bson.M{"$in": summaryIDs},
+		"matchedSummaryId": bson.M{"$in": matchedSummaryIDs},
+	}
+	return r.readMatchings(ctx, filter, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+}
+
+// FindMatchings returns a page of matchings passing the query filters, sorted by the query sort order.
+func (r *Repo) FindMatchings(ctx context.Context, query MatchingsQuery) (MatchingsPage, error) {
+	page := MatchingsPage{Matchings: make([]*Matching, 0)}
+	field, desc, err := query.sortField()
+	if err != nil {
+		return page, err
+	}
+
+	filter := matchingsFilter(query)
+	page.Total, err = r.getMatchingCollection().CountDocuments(ctx, filter)
+	if err != nil {
+		return page, repoError(err)
+	}
+
+	if query.After != "" {
+		cursor, err := decodePageCursor(query.After, query.Sort)
 		if err != nil {
-			return matching, err
+			return page, err
 		}
+		filter = bson.M{"$and": bson.A{filter, pageCursorFilter(field, desc, cursor)}}
+	}
+
+	order := 1
+	if desc {
+		order = -1
+	}
+	sort := bson.D{{Key: field, Value: order}}
+	if field != "_id" {
+		sort = append(sort, bson.E{Key: "_id", Value: 1})
+	}
+
+	limit := query.pageLimit()
+	opts := options.Find().SetSort(sort).SetLimit(int64(limit + 1))
+	matchings, err := r.readMatchings(ctx, filter, opts)
+	if err != nil {
+		return page, err
+	}
+
+	if len(matchings) > limit {
+		matchings = matchings[:limit]
+		page.Next = encodePageCursor(query.Sort, matchings[limit-1])
+	}
+	page.Matchings = matchings
+	return page, nil
+}
+
+// matchingsFilter builds MongoDB filter from query filters.
+func matchingsFilter(query MatchingsQuery) bson.M {
+	filter := bson.M{}
+	if query.SummaryId != primitive.NilObjectID {
+		filter["summaryId"] = query.SummaryId
+	}
+	if query.MatchedSummaryId != primitive.NilObjectID {
+		filter["matchedSummaryId"] = query.MatchedSummaryId
+	}
+
+	rate := bson.M{}
+	if query.MinRate != nil {
+		rate["$gte"] = *query.MinRate
+	}
+	if query.MaxRate != nil {
+		rate["$lte"] = *query.MaxRate
+	}
+	if len(rate) > 0 {
+		filter["matchRate"] = rate
+	}
+
+	created := bson.M{}
+	if !query.CreatedFrom.IsZero() {
+		created["$gte"] = query.CreatedFrom
+	}
+	if !query.CreatedTo.IsZero() {
+		created["$lt"] = query.CreatedTo
+	}
+	if len(created) > 0 {
+		filter["createdAt"] = created
+	}
+	return filter
+}
+
+// pageCursorFilter selects matchings following the cursor position in the sorted list.
+func pageCursorFilter(field string, desc bool, cursor pageCursor) bson.M {
+	if field == "_id" {
+		return bson.M{"_id": bson.M{"$gt": cursor.Id}}
+	}
+
+	var value interface{} = cursor.Rate
+	if field == "createdAt" {
+		value = cursor.CreatedAt
+	}
+	op := "$gt"
+	if desc {
+		op = "$lt"
+	}
+	return bson.M{"$or": bson.A{
+		bson.M{field: bson.M{op: value}},
+		bson.M{field: value, "_id": bson.M{"$gt": cursor.Id}},
+	}}
+}
+
+func (r Repo) readMatching(ctx context.Context, filter interface{}) (Matching, error) {
+	matching := Matching{}
+	err := r.getMatchingCollection().FindOne(ctx, filter).Decode(&matching)
+	if err != nil {
+		return Matching{}, repoError(err)
 	}
 	return matching, nil
 }
 
-func (r Repo) readMatchings(ctx context.Context, filter interface{}) ([]*Matching, error) {
+func (r Repo) readMatchings(ctx context.Context, filter interface{}, opts ...*options.FindOptions) ([]*Matching, error) {
 	result := make([]*Matching, 0)
-	cursor, _ := r.getMatchingCollection().Find(ctx, filter)
+	cursor, err := r.getMatchingCollection().Find(ctx, filter, opts...)
+	if err != nil {
+		return result, repoError(err)
+	}
+	defer cursor.Close(ctx)
+
 	for cursor.Next(ctx) {
 		matching := Matching{}
 		err := cursor.Decode(&matching)
@@ -62,27 +314,127 @@ func (r Repo) readMatchings(ctx context.Context, filter interface{}) ([]*Matchin
 		}
 		result = append(result, &matching)
 	}
-	return result, nil
+	return result, repoError(cursor.Err())
 }
 
 func (r *Repo) getDb() *mongo.Database {
 	return r.mngClient.Database(r.DbName)
 }
 
+// matchingCollection is the collection of the default namespace, namespace of scorer version
+// is kept in collection with version suffix, e.g. matching_v2.
+const matchingCollection = "matching"
+
 func (r *Repo) getMatchingCollection() *mongo.Collection {
-	return r.getDb().Collection("matching")
+	if r.namespace != "" {
+		return r.getDb().Collection(matchingCollection + "_" + r.namespace)
+	}
+	return r.getDb().Collection(matchingCollection)
+}
+
+// Namespace returns Repo of matchings of the scorer version kept in own collection.
+func (r *Repo) Namespace(version string) (DataStore, error) {
+	version = namespaceVersion(version)
+	if version != "" {
+		if err := validateScorerVersion(version); err != nil {
+			return nil, err
+		}
+	}
+	return &Repo{mngClient: r.mngClient, DbName: r.DbName, namespace: version}, nil
+}
+
+// Namespaces returns versions of namespace collections ordered by name.
+func (r *Repo) Namespaces(ctx context.Context) ([]string, error) {
+	prefix := matchingCollection + "_"
+	filter := bson.M{"name": bson.M{"$regex": "^" + prefix}}
+	names, err := r.getDb().ListCollectionNames(ctx, filter)
+	if err != nil {
+		return nil, repoError(err)
+	}
+
+	versions := make([]string, 0, len(names))
+	for _, name := range names {
+		if version := strings.TrimPrefix(name, prefix); scorerVersionPattern.MatchString(version) {
+			versions = append(versions, version)
+		}
+	}
+	sort.Strings(versions)
+	return versions, nil
 }
 
 func (r *Repo) getSummaryCollection() *mongo.Collection {
 	return r.getDb().Collection("summary")
 }
 
-func (r *Repo) CreateMatching(ctx context.Context, matching Matching) (*mongo.InsertOneResult, error) {
-	insertResult, err := r.saveNewMatching(ctx, matching)
+// GetSummary returns summary document with passed id.
+func (r *Repo) GetSummary(ctx context.Context, id primitive.ObjectID) (SummaryDocument, error) {
+	summary := SummaryDocument{}
+	err := r.getSummaryCollection().FindOne(ctx, bson.M{"_id": id}).Decode(&summary)
+	if err != nil {
+		return nil, repoError(err)
+	}
+	return summary, nil
+}
+
+// GetSummariesAfter returns at most limit summary documents with _id greater than afterID ordered by _id.
+func (r *Repo) GetSummariesAfter(ctx context.Context, afterID primitive.ObjectID, limit int) ([]SummaryDocument, error) {
+	result := make([]SummaryDocument, 0)
+	opts := options.Find().SetSort(bson.M{"_id": 1}).SetLimit(int64(limit))
+	cursor, err := r.getSummaryCollection().Find(ctx, bson.M{"_id": bson.M{"$gt": afterID}}, opts)
+	if err != nil {
+		return result, repoError(err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		summary := SummaryDocument{}
+		if err := cursor.Decode(&summary); err != nil {
+			return result, err
+		}
+		result = append(result, summary)
+	}
+	return result, repoError(cursor.Err())
+}
+
+// CountSummariesAfter returns the number of summaries with _id greater than afterID.
+func (r *Repo) CountSummariesAfter(ctx context.Context, afterID primitive.ObjectID) (int64, error) {
+	count, err := r.getSummaryCollection().CountDocuments(ctx, bson.M{"_id": bson.M{"$gt": afterID}})
+	return count, repoError(err)
+}
+
+// ExistingSummaryIds returns the set of passed ids which belong to stored summaries.
+func (r *Repo) ExistingSummaryIds(ctx context.Context, ids []primitive.ObjectID) (map[primitive.ObjectID]bool, error) {
+	existing := make(map[primitive.ObjectID]bool, len(ids))
+	if len(ids) == 0 {
+		return existing, nil
+	}
+
+	filter := bson.M{"_id": bson.M{"$in": ids}}
+	opts := options.Find().SetProjection(bson.M{"_id": 1})
+	cursor, err := r.getSummaryCollection().Find(ctx, filter, opts)
+	if err != nil {
+		return existing, repoError(err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		doc := struct {
+			Id primitive.ObjectID `bson:"_id"`
+		}{}
+		if err := cursor.Decode(&doc); err != nil {
+			return existing, err
+		}
+		existing[doc.Id] = true
+	}
+	return existing, repoError(cursor.Err())
+}
+
+func (r *Repo) CreateMatching(ctx context.Context, matching Matching) (primitive.ObjectID, error) {
+	id, err := r.saveNewMatching(ctx, matching)
 	if err != nil {
-		return insertResult, err
+		return id, repoError(err)
 	}
-	return insertResult, nil
+	return id, nil
 }
 
 func (r *Repo) UpdateMatching(ctx context.Context, matching Matching) (int64, error) {
@@ -90,7 +442,7 @@ func (r *Repo) UpdateMatching(ctx context.Context, matching Matching) (int64, er
 		// add new matching
 		_, err := r.saveNewMatching(ctx, matching)
 		if err != nil {
-			return 0, err
+			return 0, repoError(err)
 		}
 		return 1, nil
 	}
@@ -98,35 +450,230 @@ func (r *Repo) UpdateMatching(ctx context.Context, matching Matching) (int64, er
 	// update existing matching
 	updateResult, err := r.updateMatching(ctx, matching)
 	if err != nil {
-		return 0, err
+		return 0, repoError(err)
+	}
+	if updateResult.MatchedCount == 0 {
+		return 0, ErrNotFound
 	}
 
 	return updateResult.ModifiedCount, nil
 }
 
-func (r *Repo) 	saveNewMatching(ctx context.Context, matching Matching) (*mongo.InsertOneResult, error){
-	insert := bson.M{
-		"summaryId": matching.SummaryId,
+// saveNewMatching stores matching of a new summary pair. When matching of the pair
+// already exists, it is updated instead, so the pair is never duplicated.
+func (r *Repo) saveNewMatching(ctx context.Context, matching Matching) (primitive.ObjectID, error) {
+	filter := bson.M{
+		"summaryId":        matching.SummaryId,
 		"matchedSummaryId": matching.MatchedSummaryId,
-		"matchRate":   matching.MatchRate,
+	}
+	update := matchingUpdate(bson.M{
+		"matchRate": matching.MatchRate,
 		"createdAt": matching.CreatedAt,
+	}, matching)
+	opts := options.FindOneAndUpdate().
+		SetUpsert(true).
+		SetReturnDocument(options.After).
+		SetProjection(bson.M{"_id": 1})
+
+	saved := Matching{}
+	err := r.getMatchingCollection().FindOneAndUpdate(ctx, filter, update, opts).Decode(&saved)
+	if err != nil {
+		return primitive.NilObjectID, err
 	}
-	return r.getMatchingCollection().InsertOne(ctx, insert)
+	return saved.Id, nil
 }
 
 func (r *Repo) updateMatching(ctx context.Context, matching Matching) (*mongo.UpdateResult, error) {
 	filter := bson.M{"_id": matching.Id}
-	update := bson.M{
-		"$set": bson.M{
-			"_id": matching.Id,
-			"summaryId": matching.SummaryId,
+	update := matchingUpdate(bson.M{
+		"_id":              matching.Id,
+		"summaryId":        matching.SummaryId,
+		"matchedSummaryId": matching.MatchedSummaryId,
+		"matchRate":        matching.MatchRate,
+		"createdAt":        matching.CreatedAt,
+	}, matching)
+
+	return r.getMatchingCollection().UpdateOne(ctx, filter, update)
+}
+
+// matchingUpdate returns update document setting fields, scorer version and score breakdown
+// of matching. Version and breakdown of the previous score are removed when matching has none.
+func matchingUpdate(set bson.M, matching Matching) bson.M {
+	unset := bson.M{}
+	if matching.ScorerVersion != "" {
+		set["scorerVersion"] = matching.ScorerVersion
+	} else {
+		unset["scorerVersion"] = ""
+	}
+	if matching.Breakdown != nil {
+		set["breakdown"] = matching.Breakdown
+	} else {
+		unset["breakdown"] = ""
+	}
+
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	return update
+}
+
+// DeleteMatching removes matching with passed id.
+func (r *Repo) DeleteMatching(ctx context.Context, id primitive.ObjectID) error {
+	deleteResult, err := r.getMatchingCollection().DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return repoError(err)
+	}
+	if deleteResult.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Bulk item statuses reported by BulkWriteMatchings.
+const (
+	BulkStatusCreated = "created"
+	BulkStatusUpdated = "updated"
+	BulkStatusFailed  = "failed"
+)
+
+// BulkItemResult describes the outcome of a single matching written by a bulk request.
+type BulkItemResult struct {
+	Index  int                `json:"index"`
+	Id     primitive.ObjectID `json:"id,omitempty"`
+	Status string             `json:"status"`
+	Error  string             `json:"error,omitempty"`
+	Fields []FieldError       `json:"fields,omitempty"`
+}
+
+// BulkWriteMatchings writes passed matchings with a single unordered BulkWrite.
+// Matchings without Id are upserted by summaryId and matchedSummaryId pair, the rest
+// are upserted by Id. The returned slice has one result per passed matching, in the
+// same order, so a failure of one item does not affect the others. Id of the result
+// is not known when existing matching was updated by pair.
+func (r *Repo) BulkWriteMatchings(ctx context.Context, matchings []Matching) ([]BulkItemResult, error) {
+	results := make([]BulkItemResult, len(matchings))
+	if len(matchings) == 0 {
+		return results, nil
+	}
+
+	models := make([]mongo.WriteModel, len(matchings))
+	for i, matching := range matchings {
+		results[i] = BulkItemResult{Index: i, Id: matching.Id, Status: BulkStatusUpdated}
+		filter := bson.M{"_id": matching.Id}
+		set := bson.M{
+			"summaryId":        matching.SummaryId,
 			"matchedSummaryId": matching.MatchedSummaryId,
-			"matchRate":   matching.MatchRate,
-			"createdAt": matching.CreatedAt,
-		},
+			"matchRate":        matching.MatchRate,
+			"createdAt":        matching.CreatedAt,
+		}
+		if primitive.NilObjectID == matching.Id {
+			filter = bson.M{
+				"summaryId":        matching.SummaryId,
+				"matchedSummaryId": matching.MatchedSummaryId,
+			}
+			set = bson.M{
+				"matchRate": matching.MatchRate,
+				"createdAt": matching.CreatedAt,
+			}
+		}
+
+		models[i] = mongo.NewUpdateOneModel().
+			SetFilter(filter).
+			SetUpdate(matchingUpdate(set, matching)).
+			SetUpsert(true)
 	}
 
-	return r.getMatchingCollection().UpdateOne(ctx, filter, update)
+	opts := options.BulkWrite().SetOrdered(false)
+	bulkResult, err := r.getMatchingCollection().BulkWrite(ctx, models, opts)
+	if err != nil {
+		var bulkErr mongo.BulkWriteException
+		if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil {
+			return nil, repoError(err)
+		}
+		for _, writeErr := range bulkErr.WriteErrors {
+			results[writeErr.Index].Status = BulkStatusFailed
+			results[writeErr.Index].Error = writeErr.Message
+		}
+	}
+
+	if bulkResult != nil {
+		for index, id := range bulkResult.UpsertedIDs {
+			if results[index].Status != BulkStatusFailed {
+				results[index].Status = BulkStatusCreated
+				results[index].Id, _ = id.(primitive.ObjectID)
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// UpsertMatchingsByPair writes passed matchings with a single unordered BulkWrite.
+// Matching is matched by summaryId and matchedSummaryId pair, existing matching
+// of the pair is updated, otherwise new matching is created. Ids of passed matchings are ignored.
+func (r *Repo) UpsertMatchingsByPair(ctx context.Context, matchings []Matching) ([]BulkItemResult, error) {
+	pairs := make([]Matching, len(matchings))
+	for i, matching := range matchings {
+		matching.Id = primitive.NilObjectID
+		pairs[i] = matching
+	}
+	return r.BulkWriteMatchings(ctx, pairs)
+}
+
+// MongoDB server error codes translated by repoError.
+const (
+	mongoCodeBadValue                  = 2
+	mongoCodeDocumentValidationFailure = 121
+	mongoCodeDuplicateKey              = 11000
+	mongoCodeDuplicateKeyLegacy        = 11001
+	mongoCodeDuplicateKeyUpdate        = 12582
+)
+
+// repoError translates MongoDB driver error into one of store sentinel errors.
+// Errors which can not be translated are returned unchanged.
+func repoError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrNotFound
+	}
+
+	for _, code := range mongoErrorCodes(err) {
+		switch code {
+		case mongoCodeDuplicateKey, mongoCodeDuplicateKeyLegacy, mongoCodeDuplicateKeyUpdate:
+			return fmt.Errorf("%w: %v", ErrConflict, err)
+		case mongoCodeBadValue, mongoCodeDocumentValidationFailure:
+			return fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+	}
+	return err
+}
+
+// mongoErrorCodes returns server error codes carried by passed error.
+func mongoErrorCodes(err error) []int {
+	codes := make([]int, 0)
+
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) {
+		codes = append(codes, int(cmdErr.Code))
+	}
+
+	var writeErr mongo.WriteException
+	if errors.As(err, &writeErr) {
+		for _, e := range writeErr.WriteErrors {
+			codes = append(codes, e.Code)
+		}
+	}
+
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) {
+		for _, e := range bulkErr.WriteErrors {
+			codes = append(codes, e.Code)
+		}
+	}
+	return codes
 }
 
 func AddTimeoutContext(ctx context.Context) (context.Context, context.CancelFunc) {
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+func (r *Repo) getAPIKeyCollection() *mongo.Collection {
+	return r.getDb().Collection("api_key")
+}
+
+func (r *Repo) GetAPIKey(ctx context.Context, id primitive.ObjectID) (APIKey, error) {
+	key := APIKey{}
+	err := r.getAPIKeyCollection().FindOne(ctx, bson.M{"_id": id}).Decode(&key)
+	if err != nil {
+		return APIKey{}, repoError(err)
+	}
+	return key, nil
+}
+
+// GetAllAPIKeys returns all keys, revoked included, ordered by creation.
+func (r *Repo) GetAllAPIKeys(ctx context.Context) ([]APIKey, error) {
+	keys := make([]APIKey, 0)
+	cursor, err := r.getAPIKeyCollection().Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return keys, repoError(err)
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &keys); err != nil {
+		return keys, repoError(err)
+	}
+	return keys, nil
+}
+
+// SaveAPIKey creates or replaces the key.
+func (r *Repo) SaveAPIKey(ctx context.Context, key APIKey) error {
+	opts := options.Replace().SetUpsert(true)
+	_, err := r.getAPIKeyCollection().ReplaceOne(ctx, bson.M{"_id": key.Id}, key, opts)
+	return repoError(err)
+}
+
+// TouchAPIKey sets last used timestamp of the key.
+func (r *Repo) TouchAPIKey(ctx context.Context, id primitive.ObjectID, usedAt time.Time) error {
+	update := bson.M{"$set": bson.M{"lastUsedAt": usedAt}}
+	_, err := r.getAPIKeyCollection().UpdateOne(ctx, bson.M{"_id": id}, update)
+	return repoError(err)
+}
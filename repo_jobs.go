@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (r *Repo) getCheckpointCollection() *mongo.Collection {
+	return r.getDb().Collection("job_checkpoint")
+}
+
+// checkpointId returns id of the job checkpoint, jobs of namespaces keep own checkpoints.
+func (r *Repo) checkpointId(job string) string {
+	if r.namespace != "" {
+		return job + "@" + r.namespace
+	}
+	return job
+}
+
+// GetCheckpoint returns the last saved checkpoint of the job.
+func (r *Repo) GetCheckpoint(ctx context.Context, job string) (JobCheckpoint, error) {
+	checkpoint := JobCheckpoint{}
+	err := r.getCheckpointCollection().FindOne(ctx, bson.M{"_id": r.checkpointId(job)}).Decode(&checkpoint)
+	if err != nil {
+		return JobCheckpoint{}, repoError(err)
+	}
+	checkpoint.Job = job
+	return checkpoint, nil
+}
+
+// SaveCheckpoint replaces the checkpoint of the job.
+func (r *Repo) SaveCheckpoint(ctx context.Context, checkpoint JobCheckpoint) error {
+	checkpoint.Job = r.checkpointId(checkpoint.Job)
+	filter := bson.M{"_id": checkpoint.Job}
+	opts := options.Replace().SetUpsert(true)
+	_, err := r.getCheckpointCollection().ReplaceOne(ctx, filter, checkpoint, opts)
+	return repoError(err)
+}
+
+// DeleteCheckpoint removes the checkpoint of the job, missing checkpoint is not an error.
+func (r *Repo) DeleteCheckpoint(ctx context.Context, job string) error {
+	_, err := r.getCheckpointCollection().DeleteOne(ctx, bson.M{"_id": r.checkpointId(job)})
+	return repoError(err)
+}
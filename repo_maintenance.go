@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// matchingPairIndex is the name of the unique index of summaryId and matchedSummaryId pair.
+const matchingPairIndex = "summaryId_matchedSummaryId_unique"
+
+// dedupeDeleteBatch is the max number of duplicates removed with a single delete.
+const dedupeDeleteBatch = 1000
+
+// EnsureIndexes creates indexes required by the service. Creating unique pair index
+// fails with ErrConflict when duplicated pairs exist, run DedupeMatchings first.
+func (r *Repo) EnsureIndexes(ctx context.Context) error {
+	model := mongo.IndexModel{
+		Keys:    bson.D{{Key: "summaryId", Value: 1}, {Key: "matchedSummaryId", Value: 1}},
+		Options: options.Index().SetName(matchingPairIndex).SetUnique(true),
+	}
+	if _, err := r.getMatchingCollection().Indexes().CreateOne(ctx, model); err != nil {
+		return fmt.Errorf("create index %s: %w", matchingPairIndex, repoError(err))
+	}
+	return nil
+}
+
+// DedupeMatchings removes duplicated matchings of the same summary pair, matching with
+// the newest createdAt is kept. When dryRun is true duplicates are only counted.
+func (r *Repo) DedupeMatchings(ctx context.Context, dryRun bool) (DedupeResult, error) {
+	result := DedupeResult{}
+	pipeline := mongo.Pipeline{
+		{{Key: "$sort", Value: bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: bson.D{{Key: "summaryId", Value: "$summaryId"}, {Key: "matchedSummaryId", Value: "$matchedSummaryId"}}},
+			{Key: "ids", Value: bson.D{{Key: "$push", Value: "$_id"}}},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+		}}},
+		{{Key: "$match", Value: bson.D{{Key: "count", Value: bson.D{{Key: "$gt", Value: 1}}}}}},
+	}
+	opts := options.Aggregate().SetAllowDiskUse(true)
+	cursor, err := r.getMatchingCollection().Aggregate(ctx, pipeline, opts)
+	if err != nil {
+		return result, repoError(err)
+	}
+	defer cursor.Close(ctx)
+
+	duplicates := make([]primitive.ObjectID, 0, dedupeDeleteBatch)
+	for cursor.Next(ctx) {
+		group := struct {
+			Ids []primitive.ObjectID `bson:"ids"`
+		}{}
+		if err := cursor.Decode(&group); err != nil {
+			return result, err
+		}
+
+		result.Pairs++
+		duplicates = append(duplicates, group.Ids[1:]...)
+		if len(duplicates) >= dedupeDeleteBatch {
+			if err := r.deleteDuplicates(ctx, duplicates, dryRun, &result); err != nil {
+				return result, err
+			}
+			duplicates = duplicates[:0]
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return result, repoError(err)
+	}
+
+	err = r.deleteDuplicates(ctx, duplicates, dryRun, &result)
+	return result, err
+}
+
+func (r *Repo) deleteDuplicates(ctx context.Context, ids []primitive.ObjectID, dryRun bool, result *DedupeResult) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if dryRun {
+		result.Removed += int64(len(ids))
+		return nil
+	}
+
+	deleteResult, err := r.getMatchingCollection().DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return repoError(err)
+	}
+	result.Removed += deleteResult.DeletedCount
+	return nil
+}
+
+// VerifyMatchings finds matchings referencing summaries which do not exist.
+// When remove is true, found orphan matchings are removed.
+func (r *Repo) VerifyMatchings(ctx context.Context, remove bool) (VerifyReport, error) {
+	report := VerifyReport{Orphans: make([]OrphanMatching, 0)}
+	var err error
+	report.Checked, err = r.getMatchingCollection().CountDocuments(ctx, EmptyFilter)
+	if err != nil {
+		return report, repoError(err)
+	}
+
+	summaryCollection := r.getSummaryCollection().Name()
+	pipeline := mongo.Pipeline{
+		{{Key: "$lookup", Value: bson.D{
+			{Key: "from", Value: summaryCollection},
+			{Key: "localField", Value: "summaryId"},
+			{Key: "foreignField", Value: "_id"},
+			{Key: "as", Value: "summary"},
+		}}},
+		{{Key: "$lookup", Value: bson.D{
+			{Key: "from", Value: summaryCollection},
+			{Key: "localField", Value: "matchedSummaryId"},
+			{Key: "foreignField", Value: "_id"},
+			{Key: "as", Value: "matchedSummary"},
+		}}},
+		{{Key: "$project", Value: bson.D{
+			{Key: "summaryId", Value: 1},
+			{Key: "matchedSummaryId", Value: 1},
+			{Key: "missingSummary", Value: bson.D{{Key: "$eq", Value: bson.A{bson.D{{Key: "$size", Value: "$summary"}}, 0}}}},
+			{Key: "missingMatchedSummary", Value: bson.D{{Key: "$eq", Value: bson.A{bson.D{{Key: "$size", Value: "$matchedSummary"}}, 0}}}},
+		}}},
+		{{Key: "$match", Value: bson.D{{Key: "$or", Value: bson.A{
+			bson.D{{Key: "missingSummary", Value: true}},
+			bson.D{{Key: "missingMatchedSummary", Value: true}},
+		}}}}},
+	}
+	opts := options.Aggregate().SetAllowDiskUse(true)
+	cursor, err := r.getMatchingCollection().Aggregate(ctx, pipeline, opts)
+	if err != nil {
+		return report, repoError(err)
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &report.Orphans); err != nil {
+		return report, repoError(err)
+	}
+	if !remove || len(report.Orphans) == 0 {
+		return report, nil
+	}
+
+	ids := make([]primitive.ObjectID, len(report.Orphans))
+	for i, orphan := range report.Orphans {
+		ids[i] = orphan.Id
+	}
+	deleteResult, err := r.getMatchingCollection().DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return report, repoError(err)
+	}
+	report.Removed = deleteResult.DeletedCount
+	return report, nil
+}
+
+func (r *Repo) getErasureAuditCollection() *mongo.Collection {
+	return r.getDb().Collection("erasure_audit")
+}
+
+// GetSummaryIdsByProfile returns ids of all summaries of the profile.
+func (r *Repo) GetSummaryIdsByProfile(ctx context.Context, profileID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	ids := make([]primitive.ObjectID, 0)
+	opts := options.Find().SetProjection(bson.M{"_id": 1})
+	cursor, err := r.getSummaryCollection().Find(ctx, bson.M{"profileId": profileID}, opts)
+	if err != nil {
+		return ids, repoError(err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		doc := struct {
+			Id primitive.ObjectID `bson:"_id"`
+		}{}
+		if err := cursor.Decode(&doc); err != nil {
+			return ids, err
+		}
+		ids = append(ids, doc.Id)
+	}
+	return ids, repoError(cursor.Err())
+}
+
+// DeleteMatchingsBySummaries removes matchings referencing any of passed summaries in either direction.
+func (r *Repo) DeleteMatchingsBySummaries(ctx context.Context, summaryIDs []primitive.ObjectID) (int64, error) {
+	if len(summaryIDs) == 0 {
+		return 0, nil
+	}
+
+	filter := bson.M{"$or": bson.A{
+		bson.M{"summaryId": bson.M{"$in": summaryIDs}},
+		bson.M{"matchedSummaryId": bson.M{"$in": summaryIDs}},
+	}}
+	deleteResult, err := r.getMatchingCollection().DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, repoError(err)
+	}
+	return deleteResult.DeletedCount, nil
+}
+
+// SaveErasureAudit stores the erasure audit record or replaces the stored one of the same id.
+func (r *Repo) SaveErasureAudit(ctx context.Context, audit ErasureAudit) error {
+	_, err := r.getErasureAuditCollection().ReplaceOne(ctx, bson.M{"_id": audit.Id}, audit, options.Replace().SetUpsert(true))
+	return repoError(err)
+}
+
+// Ping checks that the database is reachable.
+func (r *Repo) Ping(ctx context.Context) error {
+	return r.mngClient.Ping(ctx, nil)
+}
+
+// MissingIndexes returns names of indexes created by EnsureIndexes which do not exist.
+func (r *Repo) MissingIndexes(ctx context.Context) ([]string, error) {
+	cursor, err := r.getMatchingCollection().Indexes().List(ctx)
+	if err != nil {
+		return nil, repoError(err)
+	}
+	defer cursor.Close(ctx)
+
+	existing := make(map[string]bool)
+	for cursor.Next(ctx) {
+		index := struct {
+			Name string `bson:"name"`
+		}{}
+		if err := cursor.Decode(&index); err != nil {
+			return nil, err
+		}
+		existing[index.Name] = true
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, repoError(err)
+	}
+
+	missing := make([]string, 0)
+	for _, name := range []string{matchingPairIndex} {
+		if !existing[name] {
+			missing = append(missing, name)
+		}
+	}
+	return missing, nil
+}
+
+// CountMatchings returns number of matchings from collection metadata, without scanning.
+func (r *Repo) CountMatchings(ctx context.Context) (int64, error) {
+	count, err := r.getMatchingCollection().EstimatedDocumentCount(ctx)
+	return count, repoError(err)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	iss "github.com/matryer/is"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -30,6 +31,7 @@ type Summary struct {
 }
 
 func TestAddUpdateDeleteMatching(t *testing.T) {
+	requireMongo(t)
 	t.Run("Remove matchings", testRemoveMatchings)
 	t.Run("Remove summaries", testRemoveSummaries)
 	t.Run("Get all matchings is empty", getGetMatchingCount(0))
@@ -147,12 +149,11 @@ func testAddMatching(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 
-			insRes, err := repo.CreateMatching(ctx, tt.matching)
+			id, err := repo.CreateMatching(ctx, tt.matching)
 			is.NoErr(err)
-			is.True(insRes.InsertedID != primitive.NilObjectID)
+			is.True(id != primitive.NilObjectID)
 
-			//matching, err := repo.GetMatching(ctx, insRes.InsertedID.(primitive.ObjectID))
-			tt.wantMatching.Id = insRes.InsertedID.(primitive.ObjectID)
+			tt.wantMatching.Id = id
 			matching, err := repo.GetMatching(ctx, tt.wantMatching.Id)
 			is.NoErr(err)
 			is.Equal(matching.Id, tt.wantMatching.Id)
@@ -166,6 +167,7 @@ func testAddMatching(t *testing.T) {
 }
 
 func TestNewRepo(t *testing.T) {
+	requireMongo(t)
 	newRepo := NewRepo(client, mongoDbConfig.DbName)
 	is := iss.New(t)
 	is.True(newRepo != nil)
@@ -173,25 +175,42 @@ func TestNewRepo(t *testing.T) {
 	is.Equal(newRepo.mngClient, client)
 }
 
+// envTestDbURI enables tests of Repo against the MongoDB at the given URI,
+// e.g. MATCHING_TEST_DB_URI=mongodb://localhost:27017 go test ./...
+// Other tests use MemoryStore and run without database.
+const envTestDbURI = "MATCHING_TEST_DB_URI"
+
 func TestMain(m *testing.M) {
-	setUp()
+	uri, withMongo := os.LookupEnv(envTestDbURI)
+	if withMongo {
+		setUp(uri)
+	}
 	exitVal := m.Run()
-	tearDown()
+	if withMongo {
+		tearDown()
+	}
 	os.Exit(exitVal)
 }
 
-func initConfig() {
+// requireMongo skips the test when MongoDB tests are not enabled by MATCHING_TEST_DB_URI.
+func requireMongo(t *testing.T) {
+	t.Helper()
+	if repo == nil {
+		t.Skip("MongoDB test, set " + envTestDbURI + " to run it")
+	}
+}
+
+func initConfig(uri string) {
 	cfg = Config{
 		DriverName: "mongodb",
-		DbHost:     "localhost", //# aws winawin mongodb public ip
-		DbPort:     "27017",
+		DbURI:      uri,
 		DbName:     "winawin_test_2",
 	}
 }
 
-func setUp() {
+func setUp(uri string) {
 	log.Print("Setup")
-	dbClient = initDbForTest()
+	dbClient = initDbForTest(uri)
 	repo = NewRepo(dbClient, cfg.DbName)
 }
 
@@ -205,8 +224,8 @@ func tearDown() {
 	log.Println("On tearDown - delResult.deleteCount:", delResult.DeletedCount)
 }
 
-func initDbForTest() *mongo.Client {
-	initConfig()
+func initDbForTest(uri string) *mongo.Client {
+	initConfig(uri)
 
 	mongoClient, err := NewMongoClient(cfg)
 	if err != nil {
@@ -229,6 +248,7 @@ func getGetMatchingCount(count int) func(t *testing.T) {
 }
 
 func TestRepo_GetMatching(t *testing.T) {
+	requireMongo(t)
 	type fields struct {
 		mngClient *mongo.Client
 		DbName    string
@@ -244,7 +264,13 @@ func TestRepo_GetMatching(t *testing.T) {
 		want    Matching
 		wantErr bool
 	}{
-		// TODO: Add test cases.
+		{
+			name:    "missing matching returns ErrNotFound",
+			fields:  fields{mngClient: dbClient, DbName: cfg.DbName},
+			args:    args{ctx: context.Background(), id: primitive.NewObjectID()},
+			want:    Matching{},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -253,6 +279,10 @@ func TestRepo_GetMatching(t *testing.T) {
 				DbName:    tt.fields.DbName,
 			}
 			got, err := r.GetMatching(tt.args.ctx, tt.args.id)
+			if tt.wantErr && !errors.Is(err, ErrNotFound) {
+				t.Errorf("GetMatching() error = %v, want ErrNotFound", err)
+				return
+			}
 			if (err != nil) != tt.wantErr {
 				t.Errorf("GetMatching() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -296,6 +326,7 @@ func makeObjectId(t *testing.T, id string) primitive.ObjectID {
 }
 
 func TestRepo_UpdateMatching(t *testing.T) {
+	requireMongo(t)
 
 	t.Log("UpdateMatching tests started.")
 	type args struct {
@@ -336,6 +367,7 @@ func TestRepo_UpdateMatching(t *testing.T) {
 }
 
 func TestRepo_getMatchingCollection(t *testing.T) {
+	requireMongo(t)
 	type fields struct {
 		mngClient *mongo.Client
 		DbName    string
@@ -361,6 +393,7 @@ func TestRepo_getMatchingCollection(t *testing.T) {
 }
 
 func TestRepo_readMatching(t *testing.T) {
+	requireMongo(t)
 	type fields struct {
 		mngClient *mongo.Client
 		DbName    string
@@ -397,6 +430,7 @@ func TestRepo_readMatching(t *testing.T) {
 }
 
 func TestRepo_readMatchings(t *testing.T) {
+	requireMongo(t)
 	type fields struct {
 		mngClient *mongo.Client
 		DbName    string
@@ -433,6 +467,7 @@ func TestRepo_readMatchings(t *testing.T) {
 }
 
 func TestRepo_saveNewMatching(t *testing.T) {
+	requireMongo(t)
 	type fields struct {
 		mngClient *mongo.Client
 		DbName    string
@@ -445,7 +480,7 @@ func TestRepo_saveNewMatching(t *testing.T) {
 		name    string
 		fields  fields
 		args    args
-		want    *mongo.InsertOneResult
+		want    primitive.ObjectID
 		wantErr bool
 	}{
 		// TODO: Add test cases.
@@ -469,6 +504,7 @@ func TestRepo_saveNewMatching(t *testing.T) {
 }
 
 func TestRepo_updateMatching(t *testing.T) {
+	requireMongo(t)
 	type fields struct {
 		mngClient *mongo.Client
 		DbName    string
@@ -503,3 +539,263 @@ func TestRepo_updateMatching(t *testing.T) {
 		})
 	}
 }
+
+func TestRepo_BulkWriteMatchings(t *testing.T) {
+	requireMongo(t)
+	is := iss.New(t)
+	ctx := context.Background()
+	testRemoveMatchings(t)
+	defer testRemoveMatchings(t)
+
+	existingId, err := repo.CreateMatching(ctx, Matching{
+		SummaryId:        makeObjectId(t, summaryId1),
+		MatchedSummaryId: makeObjectId(t, summaryId2),
+		MatchRate:        15,
+	})
+	is.NoErr(err)
+
+	matchings := []Matching{
+		{
+			SummaryId:        makeObjectId(t, summaryId2),
+			MatchedSummaryId: makeObjectId(t, summaryId1),
+			MatchRate:        25,
+		},
+		{
+			Id:               existingId,
+			SummaryId:        makeObjectId(t, summaryId1),
+			MatchedSummaryId: makeObjectId(t, summaryId2),
+			MatchRate:        35,
+		},
+	}
+
+	results, err := repo.BulkWriteMatchings(ctx, matchings)
+	is.NoErr(err)
+	is.Equal(len(results), 2)
+	is.Equal(results[0].Status, BulkStatusCreated)
+	is.True(results[0].Id != primitive.NilObjectID)
+	is.Equal(results[1].Status, BulkStatusUpdated)
+	is.Equal(results[1].Id, existingId)
+
+	updated, err := repo.GetMatching(ctx, existingId)
+	is.NoErr(err)
+	is.Equal(updated.MatchRate, 35)
+}
+
+func TestRepo_DedupeMatchings(t *testing.T) {
+	requireMongo(t)
+	is := iss.New(t)
+	ctx := context.Background()
+	testRemoveMatchings(t)
+	defer testRemoveMatchings(t)
+	_, _ = repo.getMatchingCollection().Indexes().DropOne(ctx, matchingPairIndex)
+
+	now := time.Now().Truncate(time.Millisecond)
+	for _, createdAt := range []time.Time{now.Add(-time.Hour), now, now.Add(-2 * time.Hour)} {
+		_, err := repo.getMatchingCollection().InsertOne(ctx, bson.M{
+			"summaryId":        makeObjectId(t, summaryId1),
+			"matchedSummaryId": makeObjectId(t, summaryId2),
+			"matchRate":        15,
+			"createdAt":        createdAt,
+		})
+		is.NoErr(err)
+	}
+	is.True(errors.Is(repo.EnsureIndexes(ctx), ErrConflict))
+
+	result, err := repo.DedupeMatchings(ctx, false)
+	is.NoErr(err)
+	is.Equal(result, DedupeResult{Pairs: 1, Removed: 2})
+
+	kept, err := repo.GetMatchingBySummaryId(ctx, makeObjectId(t, summaryId1))
+	is.NoErr(err)
+	is.True(kept.CreatedAt.Equal(now))
+
+	is.NoErr(repo.EnsureIndexes(ctx))
+	_, err = repo.getMatchingCollection().InsertOne(ctx, bson.M{
+		"summaryId":        makeObjectId(t, summaryId1),
+		"matchedSummaryId": makeObjectId(t, summaryId2),
+	})
+	is.True(err != nil)
+}
+
+func TestRepo_GetMutualMatchings(t *testing.T) {
+	requireMongo(t)
+	is := iss.New(t)
+	ctx := context.Background()
+	testRemoveMatchings(t)
+	defer testRemoveMatchings(t)
+
+	summary := makeObjectId(t, summaryId1)
+	pairs := []struct{ rate, reverseRate int }{{80, 40}, {60, 60}, {90, 0}, {50, 100}}
+	matched := make([]primitive.ObjectID, len(pairs))
+	for i, pair := range pairs {
+		matched[i] = primitive.NewObjectID()
+		_, err := repo.CreateMatching(ctx, Matching{SummaryId: summary, MatchedSummaryId: matched[i], MatchRate: pair.rate})
+		is.NoErr(err)
+		if pair.reverseRate > 0 {
+			_, err = repo.CreateMatching(ctx, Matching{SummaryId: matched[i], MatchedSummaryId: summary, MatchRate: pair.reverseRate})
+			is.NoErr(err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		query      MutualMatchingsQuery
+		wantIds    []primitive.ObjectID
+		wantScores []float64
+	}{
+		{name: "min", query: MutualMatchingsQuery{Combine: CombineMin}, wantIds: []primitive.ObjectID{matched[1], matched[3], matched[0]}, wantScores: []float64{60, 50, 40}},
+		{name: "harmonic", query: MutualMatchingsQuery{Combine: CombineHarmonic}, wantIds: []primitive.ObjectID{matched[3], matched[1], matched[0]}},
+		{name: "product", query: MutualMatchingsQuery{Combine: CombineProduct}, wantIds: []primitive.ObjectID{matched[3], matched[1], matched[0]}, wantScores: []float64{50, 36, 32}},
+		{name: "min rate of both directions", query: MutualMatchingsQuery{Combine: CombineMin, MinRate: 50}, wantIds: []primitive.ObjectID{matched[1], matched[3]}},
+		{name: "limit", query: MutualMatchingsQuery{Combine: CombineMin, Limit: 1}, wantIds: []primitive.ObjectID{matched[1]}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := iss.New(t)
+			matchings, err := repo.GetMutualMatchings(ctx, summary, tt.query)
+			is.NoErr(err)
+			gotIds := make([]primitive.ObjectID, 0)
+			gotScores := make([]float64, 0)
+			for _, m := range matchings {
+				is.Equal(m.SummaryId, summary)
+				is.Equal(m.ReverseMatchRate, pairs[indexOfId(matched, m.MatchedSummaryId)].reverseRate)
+				is.True(m.MatchingId != primitive.NilObjectID && m.ReverseMatchingId != primitive.NilObjectID)
+				gotIds = append(gotIds, m.MatchedSummaryId)
+				gotScores = append(gotScores, m.Score)
+			}
+			is.Equal(gotIds, tt.wantIds)
+			if tt.wantScores != nil {
+				is.Equal(gotScores, tt.wantScores)
+			}
+		})
+	}
+}
+
+func indexOfId(ids []primitive.ObjectID, id primitive.ObjectID) int {
+	for i := range ids {
+		if ids[i] == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// insertMatchings stores matchings as they are, so tests control their ids.
+func insertMatchings(t *testing.T, matchings ...Matching) {
+	for _, m := range matchings {
+		if _, err := repo.getMatchingCollection().InsertOne(context.Background(), m); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRepo_GetMatchingsBySummaryId(t *testing.T) {
+	requireMongo(t)
+	ctx := context.Background()
+	testRemoveMatchings(t)
+	defer testRemoveMatchings(t)
+
+	summary := makeObjectId(t, summaryId1)
+	matched := []primitive.ObjectID{primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()}
+	ids := []primitive.ObjectID{primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()}
+	// tied rates of matched[0] and matched[2] are ordered by _id, matched[2] has the lower one
+	insertMatchings(t,
+		Matching{Id: ids[1], SummaryId: summary, MatchedSummaryId: matched[0], MatchRate: 50},
+		Matching{Id: ids[2], SummaryId: summary, MatchedSummaryId: matched[1], MatchRate: 80},
+		Matching{Id: ids[0], SummaryId: summary, MatchedSummaryId: matched[2], MatchRate: 50},
+		Matching{Id: ids[3], SummaryId: summary, MatchedSummaryId: matched[3], MatchRate: 20},
+		Matching{Id: primitive.NewObjectID(), SummaryId: makeObjectId(t, summaryId2), MatchedSummaryId: summary, MatchRate: 90},
+	)
+
+	tests := []struct {
+		name  string
+		query SummaryMatchingsQuery
+		want  []primitive.ObjectID
+	}{
+		{name: "ranked by rate and id", query: SummaryMatchingsQuery{}, want: []primitive.ObjectID{matched[1], matched[2], matched[0], matched[3]}},
+		{name: "min rate", query: SummaryMatchingsQuery{MinRate: 50}, want: []primitive.ObjectID{matched[1], matched[2], matched[0]}},
+		{name: "exclude", query: SummaryMatchingsQuery{Exclude: []primitive.ObjectID{matched[1], matched[3]}}, want: []primitive.ObjectID{matched[2], matched[0]}},
+		{name: "limit", query: SummaryMatchingsQuery{Limit: 2}, want: []primitive.ObjectID{matched[1], matched[2]}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := iss.New(t)
+			matchings, err := repo.GetMatchingsBySummaryId(ctx, summary, tt.query)
+			is.NoErr(err)
+			got := make([]primitive.ObjectID, 0)
+			for _, m := range matchings {
+				is.Equal(m.SummaryId, summary)
+				got = append(got, m.MatchedSummaryId)
+			}
+			is.Equal(got, tt.want)
+		})
+	}
+}
+
+func TestRepo_FindMatchings(t *testing.T) {
+	requireMongo(t)
+	ctx := context.Background()
+	testRemoveMatchings(t)
+	defer testRemoveMatchings(t)
+
+	summary := makeObjectId(t, summaryId1)
+	rates := []int{30, 50, 50, 50, 10}
+	ids := make([]primitive.ObjectID, len(rates))
+	for i, rate := range rates {
+		ids[i] = primitive.NewObjectID()
+		insertMatchings(t, Matching{Id: ids[i], SummaryId: summary, MatchedSummaryId: primitive.NewObjectID(), MatchRate: rate})
+	}
+	insertMatchings(t, Matching{Id: primitive.NewObjectID(), SummaryId: makeObjectId(t, summaryId2), MatchedSummaryId: summary, MatchRate: 40})
+	minRate := 30
+
+	tests := []struct {
+		name      string
+		query     MatchingsQuery
+		wantPages [][]primitive.ObjectID
+		wantTotal int64
+	}{
+		{
+			name:      "by id",
+			query:     MatchingsQuery{SummaryId: summary, Limit: 2},
+			wantPages: [][]primitive.ObjectID{{ids[0], ids[1]}, {ids[2], ids[3]}, {ids[4]}},
+			wantTotal: 5,
+		},
+		{
+			name:      "by rate descending, ties by id",
+			query:     MatchingsQuery{SummaryId: summary, Sort: "-matchRate", Limit: 2},
+			wantPages: [][]primitive.ObjectID{{ids[1], ids[2]}, {ids[3], ids[0]}, {ids[4]}},
+			wantTotal: 5,
+		},
+		{
+			name:      "by rate ascending with filter",
+			query:     MatchingsQuery{SummaryId: summary, MinRate: &minRate, Sort: "matchRate", Limit: 3},
+			wantPages: [][]primitive.ObjectID{{ids[0], ids[1], ids[2]}, {ids[3]}},
+			wantTotal: 4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := iss.New(t)
+			query := tt.query
+			pages := make([][]primitive.ObjectID, 0)
+			for {
+				page, err := repo.FindMatchings(ctx, query)
+				is.NoErr(err)
+				is.Equal(page.Total, tt.wantTotal)
+				got := make([]primitive.ObjectID, 0)
+				for _, m := range page.Matchings {
+					got = append(got, m.Id)
+				}
+				pages = append(pages, got)
+				if page.Next == "" {
+					break
+				}
+				query.After = page.Next
+			}
+			is.Equal(pages, tt.wantPages)
+		})
+	}
+
+	_, err := repo.FindMatchings(ctx, MatchingsQuery{Sort: "-matchRate", After: "invalid"})
+	iss.New(t).True(err != nil) // invalid cursor
+}
@@ -5,16 +5,58 @@ import "github.com/go-chi/chi"
 func (s *Server) initRoutes() {
 	if s.Router == nil {
 		summary := NewRouter()
-		//auth := *s.authenticator
 
 		// /api/v1/matching/
 		summary.Group(func(r chi.Router) {
-			//r.Use(web.Verifier(auth.JWTAuth()))
-			//r.Use(web.Authenticator)
-			r.Get("/", s.getMatchingsHandler)
-			r.Get("/summary/{summaryId}", s.getMatchingHandler)
-			r.Post("/", s.postMatchingHandler)
-			r.Post("/bulk/", s.postMatchingsBulkHandler)
+			r.Use(s.authenticate)
+
+			r.Group(func(r chi.Router) {
+				r.Use(s.rateLimit(rateLimitGroupRead, s.rateLimits.Read))
+				r.Use(s.requireRole(RoleReader))
+				r.Use(limitBody(maxBodySize))
+				r.Get("/", s.getMatchingsHandler)
+				r.Get("/summary/{summaryId}", s.getMatchingHandler)
+				r.Get("/summary/{summaryId}/mutual", s.getMutualMatchingsHandler)
+				r.Get("/{id}", s.getMatchingByIdHandler)
+				// assignments only read matchings
+				r.Post("/assignments/stable", s.assignmentHandler(StableAssignment))
+				r.Post("/assignments/optimal", s.assignmentHandler(OptimalAssignment))
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(s.rateLimit(rateLimitGroupWrite, s.rateLimits.Write))
+				r.Use(s.requireRole(RoleWriter))
+				r.Use(s.writeNamespace)
+				r.With(limitBody(maxBulkBodySize)).Post("/bulk", s.postMatchingsBulkHandler)
+
+				r.Group(func(r chi.Router) {
+					r.Use(limitBody(maxBodySize))
+					r.Post("/", s.postMatchingHandler)
+					r.Put("/{id}", s.putMatchingHandler)
+					r.Patch("/{id}", s.patchMatchingHandler)
+					r.Delete("/{id}", s.deleteMatchingHandler)
+					r.Post("/score", s.postScoreHandler)
+				})
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(s.rateLimit(rateLimitGroupAdmin, s.rateLimits.Admin))
+				r.Use(s.requireRole(RoleAdmin))
+				r.Use(limitBody(maxBodySize))
+				r.Delete("/summary/{summaryId}", s.deleteSummaryMatchingsHandler)
+				r.Delete("/profile/{profileId}", s.deleteProfileMatchingsHandler)
+				r.Get("/jobs/recompute", s.getRecomputeJobHandler)
+				r.Post("/jobs/recompute", s.postRecomputeJobHandler)
+				r.Delete("/jobs/recompute", s.deleteRecomputeJobHandler)
+				r.Get("/verify", s.getVerifyHandler)
+				r.Post("/verify", s.postVerifyHandler)
+				if s.apiKeys != nil {
+					r.Get("/apikeys", s.getAPIKeysHandler)
+					r.Post("/apikeys", s.postAPIKeyHandler)
+					r.Post("/apikeys/{id}/rotate", s.postAPIKeyRotateHandler)
+					r.Delete("/apikeys/{id}", s.deleteAPIKeyHandler)
+				}
+			})
 		})
 		s.Router = summary
 	}
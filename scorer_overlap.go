@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io/ioutil"
+	"math"
+	"strings"
+)
+
+// Overlap modes of OverlapRule.
+const (
+	// overlapJaccard is the size of values intersection divided by the size of values union.
+	overlapJaccard = "jaccard"
+	// overlapCoverage is the part of summary values found in matched summary values.
+	overlapCoverage = "coverage"
+)
+
+const defaultOverlapScorerName = "weighted-overlap"
+
+// OverlapRule scores overlap of the values of a single summary field.
+// Field is a dot separated path to the field, e.g. "location.city".
+type OverlapRule struct {
+	Field  string  `json:"field"`
+	Weight float64 `json:"weight"`
+	Mode   string  `json:"mode,omitempty"`
+}
+
+// OverlapRules configures WeightedOverlapScorer.
+type OverlapRules struct {
+	Name string `json:"name,omitempty"`
+	// Version is reported in score breakdown, hash of the fields is used when not set.
+	Version string        `json:"version,omitempty"`
+	Fields  []OverlapRule `json:"fields"`
+}
+
+// LoadOverlapRules reads scorer rules from JSON rules file.
+func LoadOverlapRules(path string) (OverlapRules, error) {
+	rules := OverlapRules{}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return rules, err
+	}
+
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return rules, fmt.Errorf("rules file %s: %w", path, err)
+	}
+	if err := rules.Validate(); err != nil {
+		return rules, fmt.Errorf("rules file %s: %w", path, err)
+	}
+	return rules, nil
+}
+
+// Validate checks that rules have at least one field and all weights are positive.
+func (rules OverlapRules) Validate() error {
+	if len(rules.Fields) == 0 {
+		return errors.New("no fields defined")
+	}
+	for _, rule := range rules.Fields {
+		if rule.Field == "" {
+			return errors.New("field name is required")
+		}
+		if rule.Weight <= 0 {
+			return fmt.Errorf("field %s: weight has to be positive", rule.Field)
+		}
+		if rule.Mode != "" && rule.Mode != overlapJaccard && rule.Mode != overlapCoverage {
+			return fmt.Errorf("field %s: unknown mode %q", rule.Field, rule.Mode)
+		}
+	}
+	return nil
+}
+
+// WeightedOverlapScorer scores summaries by weighted overlap of their field values.
+// Fields missing in both summaries are ignored.
+type WeightedOverlapScorer struct {
+	rules   OverlapRules
+	version string
+}
+
+// NewWeightedOverlapScorer creates scorer configured by passed rules.
+func NewWeightedOverlapScorer(rules OverlapRules) (*WeightedOverlapScorer, error) {
+	if err := rules.Validate(); err != nil {
+		return nil, err
+	}
+	rules.Fields = append([]OverlapRule(nil), rules.Fields...)
+
+	version := rules.Version
+	if version == "" {
+		data, err := json.Marshal(rules.Fields)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(data)
+		version = hex.EncodeToString(sum[:4])
+	}
+	return &WeightedOverlapScorer{rules: rules, version: version}, nil
+}
+
+func (s *WeightedOverlapScorer) Name() string {
+	if s.rules.Name == "" {
+		return defaultOverlapScorerName
+	}
+	return s.rules.Name
+}
+
+// Version returns version of the rules, or the first 8 hex digits of sha256 of the fields.
+func (s *WeightedOverlapScorer) Version() string {
+	return s.version
+}
+
+func (s *WeightedOverlapScorer) Score(summary, matchedSummary SummaryDocument) (int, error) {
+	rate, _, err := s.Explain(summary, matchedSummary)
+	return rate, err
+}
+
+// Explain returns the rate and overlap score of every field found in any of summaries.
+func (s *WeightedOverlapScorer) Explain(summary, matchedSummary SummaryDocument) (int, []ScoreComponent, error) {
+	var score, totalWeight float64
+	components := make([]ScoreComponent, 0, len(s.rules.Fields))
+	for _, rule := range s.rules.Fields {
+		values := fieldValues(summary, rule.Field)
+		matchedValues := fieldValues(matchedSummary, rule.Field)
+		if len(values) == 0 && len(matchedValues) == 0 {
+			continue
+		}
+
+		fieldScore := overlap(rule.Mode, values, matchedValues)
+		totalWeight += rule.Weight
+		score += rule.Weight * fieldScore
+		components = append(components, ScoreComponent{
+			Name:   rule.Field,
+			Score:  math.Round(maxMatchRate*fieldScore*100) / 100,
+			Weight: rule.Weight,
+		})
+	}
+
+	if totalWeight == 0 {
+		return minMatchRate, components, nil
+	}
+	return int(math.Round(maxMatchRate * score / totalWeight)), components, nil
+}
+
+// overlap returns overlap of two value sets in the range from 0 to 1.
+func overlap(mode string, values, matchedValues map[string]bool) float64 {
+	common := 0
+	for value := range values {
+		if matchedValues[value] {
+			common++
+		}
+	}
+
+	if mode == overlapCoverage {
+		if len(values) == 0 {
+			return 0
+		}
+		return float64(common) / float64(len(values))
+	}
+
+	union := len(values) + len(matchedValues) - common
+	return float64(common) / float64(union)
+}
+
+// fieldValues returns the set of normalized values found at dot separated path of the document.
+// Array values are flattened, so both "go" and ["go", "java"] are valid field values.
+func fieldValues(doc SummaryDocument, path string) map[string]bool {
+	values := make(map[string]bool)
+	var current interface{} = map[string]interface{}(doc)
+	for _, key := range strings.Split(path, ".") {
+		current = lookupKey(current, key)
+		if current == nil {
+			return values
+		}
+	}
+	collectValues(current, values)
+	return values
+}
+
+func lookupKey(doc interface{}, key string) interface{} {
+	switch d := doc.(type) {
+	case map[string]interface{}:
+		return d[key]
+	case primitive.M:
+		return d[key]
+	case SummaryDocument:
+		return d[key]
+	case primitive.D:
+		for _, e := range d {
+			if e.Key == key {
+				return e.Value
+			}
+		}
+	}
+	return nil
+}
+
+func collectValues(value interface{}, values map[string]bool) {
+	switch v := value.(type) {
+	case nil:
+	case []interface{}:
+		for _, item := range v {
+			collectValues(item, values)
+		}
+	case primitive.A:
+		for _, item := range v {
+			collectValues(item, values)
+		}
+	case primitive.ObjectID:
+		values[v.Hex()] = true
+	default:
+		normalized := strings.ToLower(strings.TrimSpace(fmt.Sprint(v)))
+		if normalized != "" {
+			values[normalized] = true
+		}
+	}
+}
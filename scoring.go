@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// Bounds of the matchRate produced by scorers.
+const (
+	minMatchRate = 0
+	maxMatchRate = 100
+)
+
+// SummaryDocument is a raw profile summary document from the summary collection.
+type SummaryDocument map[string]interface{}
+
+// ID returns _id of the summary document.
+func (d SummaryDocument) ID() primitive.ObjectID {
+	id, _ := d["_id"].(primitive.ObjectID)
+	return id
+}
+
+// SummaryStore provides summary documents to the scoring engine.
+type SummaryStore interface {
+	GetSummary(ctx context.Context, id primitive.ObjectID) (SummaryDocument, error)
+	// GetSummariesAfter returns at most limit summaries with _id greater than afterID ordered by _id,
+	// primitive.NilObjectID starts with the first summary.
+	GetSummariesAfter(ctx context.Context, afterID primitive.ObjectID, limit int) ([]SummaryDocument, error)
+	// CountSummariesAfter returns the number of summaries with _id greater than afterID.
+	CountSummariesAfter(ctx context.Context, afterID primitive.ObjectID) (int64, error)
+	// ExistingSummaryIds returns the set of passed ids which belong to stored summaries.
+	ExistingSummaryIds(ctx context.Context, ids []primitive.ObjectID) (map[primitive.ObjectID]bool, error)
+}
+
+// Scorer computes how well summary matches matchedSummary.
+// Returned rate is in the range from 0 to 100.
+type Scorer interface {
+	Name() string
+	Score(summary, matchedSummary SummaryDocument) (int, error)
+}
+
+// ExplainingScorer is a Scorer which also reports weighted components of the rate.
+type ExplainingScorer interface {
+	Scorer
+	// Version identifies configuration of the scorer, it changes with weights.
+	Version() string
+	Explain(summary, matchedSummary SummaryDocument) (int, []ScoreComponent, error)
+}
+
+// ScoringEngine computes matchings from summary documents with passed Scorer.
+type ScoringEngine struct {
+	summaries SummaryStore
+	scorer    Scorer
+}
+
+// NewScoringEngine creates scoring engine reading summaries from passed store.
+func NewScoringEngine(summaries SummaryStore, scorer Scorer) *ScoringEngine {
+	return &ScoringEngine{summaries: summaries, scorer: scorer}
+}
+
+// Score loads both summaries and returns the matching of summaryID to matchedSummaryID.
+// Returned matching is not saved.
+func (e *ScoringEngine) Score(ctx context.Context, summaryID, matchedSummaryID primitive.ObjectID) (Matching, error) {
+	summary, err := e.summaries.GetSummary(ctx, summaryID)
+	if err != nil {
+		return Matching{}, fmt.Errorf("summary %s: %w", summaryID.Hex(), err)
+	}
+
+	matchedSummary, err := e.summaries.GetSummary(ctx, matchedSummaryID)
+	if err != nil {
+		return Matching{}, fmt.Errorf("summary %s: %w", matchedSummaryID.Hex(), err)
+	}
+
+	return e.ScoreDocuments(summaryID, matchedSummaryID, summary, matchedSummary)
+}
+
+// Version returns version of the scorer, empty when the scorer is not ExplainingScorer.
+func (e *ScoringEngine) Version() string {
+	if explaining, ok := e.scorer.(ExplainingScorer); ok {
+		return explaining.Version()
+	}
+	return ""
+}
+
+// ScoreDocuments returns the matching of already loaded summary documents.
+// Matching has scorer version and score breakdown when the scorer is ExplainingScorer.
+func (e *ScoringEngine) ScoreDocuments(summaryID, matchedSummaryID primitive.ObjectID, summary, matchedSummary SummaryDocument) (Matching, error) {
+	var rate int
+	var breakdown *ScoreBreakdown
+	var err error
+	if explaining, ok := e.scorer.(ExplainingScorer); ok {
+		var components []ScoreComponent
+		rate, components, err = explaining.Explain(summary, matchedSummary)
+		breakdown = &ScoreBreakdown{Scorer: explaining.Name(), Version: explaining.Version(), Components: components}
+	} else {
+		rate, err = e.scorer.Score(summary, matchedSummary)
+	}
+	if err != nil {
+		return Matching{}, fmt.Errorf("scorer %s: %w", e.scorer.Name(), err)
+	}
+
+	return Matching{
+		SummaryId:        summaryID,
+		MatchedSummaryId: matchedSummaryID,
+		MatchRate:        clampRate(rate),
+		CreatedAt:        time.Now().UTC().Truncate(time.Millisecond),
+		ScorerVersion:    e.Version(),
+		Breakdown:        breakdown,
+	}, nil
+}
+
+func clampRate(rate int) int {
+	if rate < minMatchRate {
+		return minMatchRate
+	}
+	if rate > maxMatchRate {
+		return maxMatchRate
+	}
+	return rate
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	iss "github.com/matryer/is"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"testing"
+)
+
+func TestWeightedOverlapScorer_Score(t *testing.T) {
+	rules := OverlapRules{Fields: []OverlapRule{
+		{Field: "skills", Weight: 60},
+		{Field: "location.city", Weight: 20},
+		{Field: "languages", Weight: 20, Mode: overlapCoverage},
+	}}
+	scorer, err := NewWeightedOverlapScorer(rules)
+	iss.New(t).NoErr(err)
+
+	tests := []struct {
+		name           string
+		summary        SummaryDocument
+		matchedSummary SummaryDocument
+		want           int
+	}{
+		{
+			name:           "identical",
+			summary:        SummaryDocument{"skills": []interface{}{"Go", "SQL"}, "location": map[string]interface{}{"city": "Vilnius"}},
+			matchedSummary: SummaryDocument{"skills": []interface{}{"go", "sql"}, "location": map[string]interface{}{"city": "vilnius"}},
+			want:           100,
+		},
+		{
+			name:           "half skills, other city",
+			summary:        SummaryDocument{"skills": primitive.A{"go", "sql"}, "location": primitive.D{{Key: "city", Value: "Vilnius"}}},
+			matchedSummary: SummaryDocument{"skills": primitive.A{"go"}, "location": primitive.D{{Key: "city", Value: "Kaunas"}}},
+			want:           38,
+		},
+		{
+			name:           "coverage is directional",
+			summary:        SummaryDocument{"languages": []interface{}{"en"}},
+			matchedSummary: SummaryDocument{"languages": []interface{}{"en", "lt"}},
+			want:           100,
+		},
+		{
+			name:           "coverage reversed",
+			summary:        SummaryDocument{"languages": []interface{}{"en", "lt"}},
+			matchedSummary: SummaryDocument{"languages": []interface{}{"en"}},
+			want:           50,
+		},
+		{
+			name:           "no common fields",
+			summary:        SummaryDocument{},
+			matchedSummary: SummaryDocument{},
+			want:           0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := iss.New(t)
+			got, err := scorer.Score(tt.summary, tt.matchedSummary)
+			is.NoErr(err)
+			is.Equal(got, tt.want)
+		})
+	}
+}
+
+func TestWeightedOverlapScorer_Explain(t *testing.T) {
+	is := iss.New(t)
+	rules := OverlapRules{Fields: []OverlapRule{
+		{Field: "skills", Weight: 40},
+		{Field: "location.city", Weight: 20},
+		{Field: "interests", Weight: 10},
+	}}
+	scorer, err := NewWeightedOverlapScorer(rules)
+	is.NoErr(err)
+
+	rate, components, err := scorer.Explain(
+		SummaryDocument{"skills": []interface{}{"go", "sql", "k8s"}, "location": map[string]interface{}{"city": "Vilnius"}},
+		SummaryDocument{"skills": []interface{}{"go"}, "location": map[string]interface{}{"city": "Vilnius"}})
+	is.NoErr(err)
+	is.Equal(rate, 56)
+	is.Equal(components, []ScoreComponent{{Name: "skills", Score: 33.33, Weight: 40}, {Name: "location.city", Score: 100, Weight: 20}})
+
+	// version follows weights unless it is set by rules
+	version := scorer.Version()
+	is.Equal(len(version), 8)
+	rules.Fields[2].Weight = 15
+	changed, err := NewWeightedOverlapScorer(rules)
+	is.NoErr(err)
+	is.True(changed.Version() != version)
+	rules.Version = "2020-06"
+	named, err := NewWeightedOverlapScorer(rules)
+	is.NoErr(err)
+	is.Equal(named.Version(), "2020-06")
+}
+
+func TestOverlapRules_Validate(t *testing.T) {
+	is := iss.New(t)
+	is.True(OverlapRules{}.Validate() != nil)
+	is.True(OverlapRules{Fields: []OverlapRule{{Field: "skills"}}}.Validate() != nil)
+	is.True(OverlapRules{Fields: []OverlapRule{{Field: "skills", Weight: 1, Mode: "exact"}}}.Validate() != nil)
+	is.NoErr(OverlapRules{Fields: []OverlapRule{{Field: "skills", Weight: 1}}}.Validate())
+
+	_, err := LoadOverlapRules("scoring-rules.example.json")
+	is.NoErr(err)
+}
+
+func TestScoringEngine_Score(t *testing.T) {
+	is := iss.New(t)
+	store := NewMemoryStore()
+	summary, matchedSummary := primitive.NewObjectID(), primitive.NewObjectID()
+	store.PutSummary(summary, SummaryDocument{"skills": []interface{}{"go", "sql"}})
+	store.PutSummary(matchedSummary, SummaryDocument{"skills": []interface{}{"go"}})
+
+	scorer, err := NewWeightedOverlapScorer(OverlapRules{Fields: []OverlapRule{{Field: "skills", Weight: 1}}})
+	is.NoErr(err)
+	engine := NewScoringEngine(store, scorer)
+
+	matching, err := engine.Score(context.Background(), summary, matchedSummary)
+	is.NoErr(err)
+	is.Equal(matching.SummaryId, summary)
+	is.Equal(matching.MatchedSummaryId, matchedSummary)
+	is.Equal(matching.MatchRate, 50)
+	is.True(!matching.CreatedAt.IsZero())
+	is.Equal(matching.Breakdown, &ScoreBreakdown{Scorer: defaultOverlapScorerName, Version: scorer.Version(),
+		Components: []ScoreComponent{{Name: "skills", Score: 50, Weight: 1}}})
+
+	_, err = engine.Score(context.Background(), summary, primitive.NewObjectID())
+	is.True(errors.Is(err, ErrNotFound))
+}
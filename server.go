@@ -1,30 +1,101 @@
 package main
 
 import (
+	"context"
 	"github.com/go-chi/chi"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type Server struct {
 	//Router http.Handler
-	repo   *Repo
-	Router *chi.Mux
-	build  string
-	//authenticator *auth.Authenticator
+	repo      MatchingStore
+	store     DataStore
+	scoring   *ScoringEngine
+	recompute *RecomputeJob
+	Router    *chi.Mux
+	build     string
+	// authenticator verifies tokens, API is open when nil
+	authenticator *Authenticator
+	// apiKeys verifies X-API-Key header, API keys are rejected when nil
+	apiKeys *APIKeyManager
+	// rateLimits are requests per minute of a client per route group
+	rateLimits RateLimits
+	limiter    *rateLimiter
+	// versions routes reads to namespaces of scorer versions
+	versions VersionRouting
 }
 
 type Service struct {
 	repo *Repo
 }
 
+// ServerOption configures optional features of the Server.
+type ServerOption func(s *Server)
+
+// WithScoring enables scoring and recompute job endpoints.
+func WithScoring(scoring *ScoringEngine, recompute *RecomputeJob) ServerOption {
+	return func(s *Server) {
+		s.scoring = scoring
+		s.recompute = recompute
+	}
+}
+
+// WithSummaryChecks rejects writes of matchings referencing summaries which do not exist.
+func WithSummaryChecks() ServerOption {
+	return func(s *Server) {
+		s.repo = NewIntegrityStore(s.repo, s.store)
+	}
+}
+
+// WithAuthenticator requires bearer tokens and enforces roles of their claims on all routes.
+func WithAuthenticator(authenticator *Authenticator) ServerOption {
+	return func(s *Server) {
+		s.authenticator = authenticator
+	}
+}
+
+// WithAPIKeys accepts API keys kept in the store and enables API key management endpoints.
+// Requests without a key or a token are rejected.
+func WithAPIKeys() ServerOption {
+	return func(s *Server) {
+		s.apiKeys = NewAPIKeyManager(s.store)
+	}
+}
+
+// WithRateLimits limits requests of each client per route group.
+func WithRateLimits(limits RateLimits) ServerOption {
+	return func(s *Server) {
+		s.rateLimits = limits
+	}
+}
+
+// WithVersionRouting serves reads of matchings from namespaces of scorer versions.
+func WithVersionRouting(routing VersionRouting) ServerOption {
+	return func(s *Server) {
+		s.versions = routing
+	}
+}
+
 // NewServer is a factory function which creates and initializes new user REST API server.
-func NewServer(build string, dbName string, mngClient *mongo.Client) *Server {
+// Passed store is either MongoDB backed Repo or in-memory MemoryStore.
+func NewServer(build string, store DataStore, opts ...ServerOption) *Server {
 	s := Server{
-		build: build,
-		repo:  NewRepo(mngClient, dbName),
+		build:   build,
+		repo:    store,
+		store:   store,
+		limiter: newRateLimiter(),
+	}
+	for _, opt := range opts {
+		opt(&s)
 	}
 
 	s.initRoutes()
 	return &s
 }
 
+// Shutdown stops background jobs of the server and waits until they are finished or ctx is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s.recompute == nil {
+		return nil
+	}
+	return s.recompute.Shutdown(ctx)
+}
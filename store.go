@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Errors returned by MatchingStore implementations. Errors may be wrapped with details,
+// use errors.Is to check them.
+var (
+	// ErrNotFound is returned when requested matching does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when write conflicts with already stored data.
+	ErrConflict = errors.New("conflict")
+	// ErrInvalid is returned when passed data is rejected by the store.
+	ErrInvalid = errors.New("invalid data")
+)
+
+// MatchingStore is the storage used by matching REST API server.
+// Repo is the MongoDB implementation, MemoryStore keeps matchings in memory.
+type MatchingStore interface {
+	GetMatching(ctx context.Context, id primitive.ObjectID) (Matching, error)
+	GetMatchingBySummaryId(ctx context.Context, summaryID primitive.ObjectID) (Matching, error)
+	GetMatchingsBySummaryId(ctx context.Context, summaryID primitive.ObjectID, query SummaryMatchingsQuery) ([]*Matching, error)
+	GetMutualMatchings(ctx context.Context, summaryID primitive.ObjectID, query MutualMatchingsQuery) ([]MutualMatching, error)
+	GetAllMatchings(ctx context.Context) ([]*Matching, error)
+	// IterateMatchingsBySummary streams all matchings grouped by summary, so they are never held in memory at once.
+	IterateMatchingsBySummary(ctx context.Context) (MatchingsIterator, error)
+	GetMatchingsBetween(ctx context.Context, summaryIDs, matchedSummaryIDs []primitive.ObjectID) ([]*Matching, error)
+	FindMatchings(ctx context.Context, query MatchingsQuery) (MatchingsPage, error)
+	// CreateMatching returns id of the stored matching, existing matching of the summary pair is updated.
+	CreateMatching(ctx context.Context, matching Matching) (primitive.ObjectID, error)
+	UpdateMatching(ctx context.Context, matching Matching) (int64, error)
+	DeleteMatching(ctx context.Context, id primitive.ObjectID) error
+	BulkWriteMatchings(ctx context.Context, matchings []Matching) ([]BulkItemResult, error)
+	UpsertMatchingsByPair(ctx context.Context, matchings []Matching) ([]BulkItemResult, error)
+}
+
+// CheckpointStore keeps progress of background jobs, so interrupted job can be resumed.
+type CheckpointStore interface {
+	// GetCheckpoint returns ErrNotFound when job has no checkpoint.
+	GetCheckpoint(ctx context.Context, job string) (JobCheckpoint, error)
+	SaveCheckpoint(ctx context.Context, checkpoint JobCheckpoint) error
+	DeleteCheckpoint(ctx context.Context, job string) error
+}
+
+// MaintenanceStore runs maintenance tasks of the matching storage.
+type MaintenanceStore interface {
+	// EnsureIndexes creates indexes required by the service, including unique summary pair index.
+	EnsureIndexes(ctx context.Context) error
+	// DedupeMatchings removes duplicated matchings of the same summary pair keeping the newest one.
+	DedupeMatchings(ctx context.Context, dryRun bool) (DedupeResult, error)
+}
+
+// DedupeResult reports duplicates found by DedupeMatchings.
+type DedupeResult struct {
+	// Pairs is the number of summary pairs having more than one matching.
+	Pairs int64 `json:"pairs"`
+	// Removed is the number of removed matchings, or matchings to remove on dry run.
+	Removed int64 `json:"removed"`
+}
+
+// DataStore combines all stores used by the service.
+type DataStore interface {
+	MatchingStore
+	SummaryStore
+	CheckpointStore
+	MaintenanceStore
+	IntegrityStore
+	ErasureStore
+	HealthStore
+	APIKeyStore
+	NamespaceStore
+}
+
+var (
+	_ DataStore = (*Repo)(nil)
+	_ DataStore = (*MemoryStore)(nil)
+)
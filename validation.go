@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FieldError describes a single invalid field of the request payload.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every invalid field of the request payload.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		messages[i] = f.Field + ": " + f.Message
+	}
+	return "validation failed: " + strings.Join(messages, "; ")
+}
+
+func (e *ValidationError) add(field, message string) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: message})
+}
+
+func (e *ValidationError) has(field string) bool {
+	for _, f := range e.Fields {
+		if f.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// orNil returns nil when no field errors were added, so the result can be returned as error.
+func (e *ValidationError) orNil() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// RespondValidationError responds with 422 status and lists every invalid field
+// in the same error shape as RespondError.
+func RespondValidationError(w http.ResponseWriter, r *http.Request, err *ValidationError) {
+	Respond(w, r, http.StatusUnprocessableEntity, map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": "validation failed",
+			"fields":  err.Fields,
+		},
+	})
+}
+
+// DecodeStrictJSON decodes JSON object data into struct pointed by v. Unlike json.Unmarshal
+// it does not stop on the first invalid field, it returns *ValidationError listing every
+// unknown field and every field with value of invalid type. Field names are case sensitive.
+func DecodeStrictJSON(data []byte, v interface{}) error {
+	raw := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	target := reflect.ValueOf(v).Elem()
+	fields := make(map[string]int)
+	for i := 0; i < target.NumField(); i++ {
+		name := strings.Split(target.Type().Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			fields[name] = i
+		}
+	}
+
+	keys := make([]string, 0, len(raw))
+	for key := range raw {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	verr := &ValidationError{}
+	for _, key := range keys {
+		i, ok := fields[key]
+		if !ok {
+			verr.add(key, "unknown field")
+			continue
+		}
+		if err := json.Unmarshal(raw[key], target.Field(i).Addr().Interface()); err != nil {
+			verr.add(key, "invalid value")
+		}
+	}
+	return verr.orNil()
+}
+
+// ValidateMatching checks matching payload and sets CreatedAt to now when it is missing.
+// Fields already reported by verr are not checked again.
+func ValidateMatching(matching *Matching, verr *ValidationError) error {
+	if !verr.has("summaryId") && matching.SummaryId == primitive.NilObjectID {
+		verr.add("summaryId", "is required")
+	}
+	if !verr.has("matchedSummaryId") {
+		switch {
+		case matching.MatchedSummaryId == primitive.NilObjectID:
+			verr.add("matchedSummaryId", "is required")
+		case matching.MatchedSummaryId == matching.SummaryId:
+			verr.add("matchedSummaryId", "must differ from summaryId")
+		}
+	}
+	if !verr.has("matchRate") && (matching.MatchRate < minMatchRate || matching.MatchRate > maxMatchRate) {
+		verr.add("matchRate", fmt.Sprintf("must be between %d and %d", minMatchRate, maxMatchRate))
+	}
+	if matching.Breakdown != nil && !verr.has("breakdown") {
+		validateBreakdown(matching.Breakdown, verr)
+	}
+	if matching.CreatedAt.IsZero() {
+		matching.CreatedAt = time.Now().UTC().Truncate(time.Millisecond)
+	}
+	return verr.orNil()
+}
+
+func validateBreakdown(breakdown *ScoreBreakdown, verr *ValidationError) {
+	if breakdown.Scorer == "" {
+		verr.add("breakdown.scorer", "is required")
+	}
+	for i, component := range breakdown.Components {
+		field := fmt.Sprintf("breakdown.components[%d]", i)
+		switch {
+		case component.Name == "":
+			verr.add(field+".name", "is required")
+		case component.Score < minMatchRate || component.Score > maxMatchRate:
+			verr.add(field+".score", fmt.Sprintf("must be between %d and %d", minMatchRate, maxMatchRate))
+		case component.Weight < 0:
+			verr.add(field+".weight", "must not be negative")
+		}
+	}
+}
+
+// decodeMatching decodes and validates a single matching payload.
+// It returns *ValidationError listing every invalid field, or error of malformed JSON.
+func decodeMatching(data []byte) (Matching, error) {
+	matching := Matching{}
+	verr := &ValidationError{}
+	if err := DecodeStrictJSON(data, &matching); err != nil {
+		fieldErr, ok := err.(*ValidationError)
+		if !ok {
+			return matching, err
+		}
+		verr = fieldErr
+	}
+	return matching, ValidateMatching(&matching, verr)
+}
+
+// decodeMatchingBody reads request body and decodes and validates matching payload.
+func decodeMatchingBody(body io.Reader) (Matching, error) {
+	defer io.Copy(ioutil.Discard, body)
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return Matching{}, err
+	}
+	return decodeMatching(data)
+}
+
+// respondDecodeError responds with 422 to validation errors and with 400 to malformed payload.
+func respondDecodeError(w http.ResponseWriter, r *http.Request, err error) {
+	if verr, ok := err.(*ValidationError); ok {
+		RespondValidationError(w, r, verr)
+		return
+	}
+	RespondError(w, r, http.StatusBadRequest, err)
+}
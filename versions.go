@@ -0,0 +1,450 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// headerScorerVersion reports the scorer version namespace which served the read request.
+const headerScorerVersion = "X-Scorer-Version"
+
+// defaultNamespace names the namespace of matchings not kept apart by scorer version.
+const defaultNamespace = "default"
+
+// scorerVersionPattern restricts versions to names usable in collection names.
+var scorerVersionPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// NamespaceStore keeps matchings of several scorer versions in parallel namespaces,
+// so results of a new scorer can be compared before they replace the current ones.
+type NamespaceStore interface {
+	// Namespace returns the store of matchings of the scorer version, other data is shared.
+	// Empty version and defaultNamespace return the default namespace.
+	Namespace(version string) (DataStore, error)
+	// Namespaces returns versions of existing namespaces, default namespace is not included.
+	Namespaces(ctx context.Context) ([]string, error)
+}
+
+// validateScorerVersion checks the version can name a namespace.
+func validateScorerVersion(version string) error {
+	if version == defaultNamespace || !scorerVersionPattern.MatchString(version) {
+		return fmt.Errorf("%w: scorer version %q, use letters, digits, _ or -", ErrInvalid, version)
+	}
+	return nil
+}
+
+// namespaceVersion maps defaultNamespace to empty version.
+func namespaceVersion(version string) string {
+	if version == defaultNamespace {
+		return ""
+	}
+	return version
+}
+
+// VersionRouting selects the scorer version namespace serving reads of matchings.
+type VersionRouting struct {
+	// Default is the version serving reads, empty means the default namespace.
+	Default string `yaml:"default"`
+	// Candidate is the version serving reads of Percent of profiles.
+	Candidate string `yaml:"candidate"`
+	Percent   int    `yaml:"percent"`
+}
+
+// Enabled reports whether reads are served from other than the default namespace.
+func (v VersionRouting) Enabled() bool {
+	return namespaceVersion(v.Default) != "" || (v.Candidate != "" && v.Percent > 0)
+}
+
+// Validate checks versions and percent of the routing.
+func (v VersionRouting) Validate() error {
+	if v.Default != "" && v.Default != defaultNamespace {
+		if err := validateScorerVersion(v.Default); err != nil {
+			return err
+		}
+	}
+	if v.Candidate != "" && v.Candidate != defaultNamespace {
+		if err := validateScorerVersion(v.Candidate); err != nil {
+			return err
+		}
+	}
+	if v.Percent < 0 || v.Percent > 100 {
+		return errors.New("candidate percent must be between 0 and 100")
+	}
+	if v.Percent > 0 && v.Candidate == "" {
+		return errors.New("candidate version is required by candidate percent")
+	}
+	return nil
+}
+
+// route returns the version serving profile, profiles are split by hash of the key,
+// so a profile is always served by the same version.
+func (v VersionRouting) route(key string) string {
+	if key != "" && v.Candidate != "" && versionBucket(key) < v.Percent {
+		return namespaceVersion(v.Candidate)
+	}
+	return namespaceVersion(v.Default)
+}
+
+// versionBucket returns bucket of the key from 0 to 99.
+func versionBucket(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % 100)
+}
+
+// readStore returns the store serving matchings of the read request. Version query parameter
+// selects an existing namespace explicitly, unknown version is ErrNotFound. Otherwise routing splits
+// profiles of the caller or of the summary. Served version is reported by X-Scorer-Version header.
+func (s *Server) readStore(w http.ResponseWriter, r *http.Request, summaryID primitive.ObjectID) (MatchingStore, error) {
+	version := r.URL.Query().Get("version")
+	if version != "" {
+		if err := checkNamespace(r.Context(), s.store, version); err != nil {
+			return nil, err
+		}
+	} else if s.versions.Enabled() {
+		key, err := s.routingKey(r.Context(), summaryID)
+		if err != nil {
+			return nil, err
+		}
+		version = s.versions.route(key)
+	} else {
+		return s.repo, nil
+	}
+
+	if version = namespaceVersion(version); version == "" {
+		w.Header().Set(headerScorerVersion, defaultNamespace)
+		return s.repo, nil
+	}
+	store, err := s.store.Namespace(version)
+	if err != nil {
+		return nil, err
+	}
+	w.Header().Set(headerScorerVersion, version)
+	return store, nil
+}
+
+// checkNamespace returns ErrNotFound when no matchings of the version were stored,
+// so reads never create namespaces. Default namespace always exists.
+func checkNamespace(ctx context.Context, store NamespaceStore, version string) error {
+	if version = namespaceVersion(version); version == "" {
+		return nil
+	}
+	if err := validateScorerVersion(version); err != nil {
+		return err
+	}
+	versions, err := store.Namespaces(ctx)
+	if err != nil {
+		return err
+	}
+	for _, v := range versions {
+		if v == version {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: scorer version %q", ErrNotFound, version)
+}
+
+// writeNamespace reports the default namespace on writes while reads are routed by scorer version.
+// Writes always go to the default namespace, matchings of other versions are written by recompute.
+func (s *Server) writeNamespace(next http.Handler) http.Handler {
+	if !s.versions.Enabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(headerScorerVersion, defaultNamespace)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// routingKey returns profile id of the caller or of the summary, or summary id when the
+// summary has no profile. Empty key means the request is not split.
+func (s *Server) routingKey(ctx context.Context, summaryID primitive.ObjectID) (string, error) {
+	if claims, ok := ClaimsFromContext(ctx); ok && claims.ProfileId != "" {
+		return claims.ProfileId, nil
+	}
+	if summaryID.IsZero() {
+		return "", nil
+	}
+
+	summary, err := s.store.GetSummary(ctx, summaryID)
+	if errors.Is(err, ErrNotFound) {
+		return summaryID.Hex(), nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if profileID := profileIdOf(summary); profileID != "" {
+		return profileID, nil
+	}
+	return summaryID.Hex(), nil
+}
+
+// VersionComparison compares matchings of two scorer versions.
+type VersionComparison struct {
+	Base      string `json:"base"`
+	Candidate string `json:"candidate"`
+	// Summaries is the number of summaries having matchings in both versions.
+	Summaries int `json:"summaries"`
+	// CommonPairs are scored by both versions, the other pairs by one of them only.
+	CommonPairs        int `json:"commonPairs"`
+	BaseOnlyPairs      int `json:"baseOnlyPairs"`
+	CandidateOnlyPairs int `json:"candidateOnlyPairs"`
+	// RankCorrelation is the mean Spearman correlation of ranked matchings of a summary,
+	// summaries with less than 2 common pairs are not included.
+	RankCorrelation float64 `json:"rankCorrelation"`
+	// TopKOverlap is the mean part of top K matched summaries of a summary found in both versions.
+	TopK        int     `json:"topK"`
+	TopKOverlap float64 `json:"topKOverlap"`
+	// Rate deltas are candidate minus base matchRate of common pairs.
+	MeanRateDelta    float64 `json:"meanRateDelta"`
+	MeanAbsRateDelta float64 `json:"meanAbsRateDelta"`
+	MaxAbsRateDelta  int     `json:"maxAbsRateDelta"`
+}
+
+// SummaryMatchings are matchings of one summary.
+type SummaryMatchings struct {
+	SummaryId primitive.ObjectID
+	Matchings []*Matching
+}
+
+// MatchingsIterator returns matchings grouped by summary in ascending summaryId order.
+type MatchingsIterator interface {
+	// Next returns matchings of the next summary, io.EOF after the last one.
+	Next(ctx context.Context) (SummaryMatchings, error)
+	Close(ctx context.Context) error
+}
+
+// sliceMatchingsIterator groups matchings of a slice sorted by summaryId.
+type sliceMatchingsIterator struct {
+	matchings []*Matching
+}
+
+func (it *sliceMatchingsIterator) Next(ctx context.Context) (SummaryMatchings, error) {
+	if len(it.matchings) == 0 {
+		return SummaryMatchings{}, io.EOF
+	}
+	n := 1
+	for n < len(it.matchings) && it.matchings[n].SummaryId == it.matchings[0].SummaryId {
+		n++
+	}
+	group := SummaryMatchings{SummaryId: it.matchings[0].SummaryId, Matchings: it.matchings[:n]}
+	it.matchings = it.matchings[n:]
+	return group, nil
+}
+
+func (it *sliceMatchingsIterator) Close(ctx context.Context) error {
+	return nil
+}
+
+// CompareVersionIterators compares matchings of two versions streamed by summary, so only
+// matchings of one summary of each version are held in memory at a time.
+func CompareVersionIterators(ctx context.Context, base, candidate MatchingsIterator, topK int) (VersionComparison, error) {
+	c := versionComparer{result: VersionComparison{TopK: topK}}
+	b, err := nextSummaryMatchings(ctx, base)
+	if err != nil {
+		return c.result, err
+	}
+	n, err := nextSummaryMatchings(ctx, candidate)
+	if err != nil {
+		return c.result, err
+	}
+	for b != nil || n != nil {
+		order := 0
+		switch {
+		case b == nil:
+			order = 1
+		case n == nil:
+			order = -1
+		default:
+			order = bytes.Compare(b.SummaryId[:], n.SummaryId[:])
+		}
+
+		var baseOf, candidateOf map[primitive.ObjectID]int
+		if order <= 0 {
+			baseOf = groupRates(b.Matchings)[b.SummaryId]
+			if b, err = nextSummaryMatchings(ctx, base); err != nil {
+				return c.result, err
+			}
+		}
+		if order >= 0 {
+			candidateOf = groupRates(n.Matchings)[n.SummaryId]
+			if n, err = nextSummaryMatchings(ctx, candidate); err != nil {
+				return c.result, err
+			}
+		}
+		c.add(baseOf, candidateOf)
+	}
+	return c.finish(), nil
+}
+
+// nextSummaryMatchings returns nil after the last summary of the iterator.
+func nextSummaryMatchings(ctx context.Context, it MatchingsIterator) (*SummaryMatchings, error) {
+	group, err := it.Next(ctx)
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+// versionComparer accumulates the comparison one summary at a time.
+type versionComparer struct {
+	result                VersionComparison
+	correlations          []float64
+	overlaps              []float64
+	deltaSum, absDeltaSum float64
+}
+
+// add compares rates of one summary by matched summary, nil rates mean the version has no matchings of it.
+func (c *versionComparer) add(baseOf, candidateOf map[primitive.ObjectID]int) {
+	if baseOf == nil || candidateOf == nil {
+		c.result.BaseOnlyPairs += len(baseOf)
+		c.result.CandidateOnlyPairs += len(candidateOf)
+		return
+	}
+	c.result.Summaries++
+
+	common := make([]primitive.ObjectID, 0)
+	for matchedID, rate := range baseOf {
+		candidateRate, ok := candidateOf[matchedID]
+		if !ok {
+			c.result.BaseOnlyPairs++
+			continue
+		}
+		common = append(common, matchedID)
+		delta := candidateRate - rate
+		c.deltaSum += float64(delta)
+		c.absDeltaSum += math.Abs(float64(delta))
+		if abs := int(math.Abs(float64(delta))); abs > c.result.MaxAbsRateDelta {
+			c.result.MaxAbsRateDelta = abs
+		}
+	}
+	c.result.CommonPairs += len(common)
+	c.result.CandidateOnlyPairs += len(candidateOf) - len(common)
+
+	if len(common) >= 2 {
+		c.correlations = append(c.correlations, spearman(common, baseOf, candidateOf))
+	}
+	if c.result.TopK > 0 {
+		c.overlaps = append(c.overlaps, topKOverlap(baseOf, candidateOf, c.result.TopK))
+	}
+}
+
+func (c *versionComparer) finish() VersionComparison {
+	result := c.result
+	result.RankCorrelation = mean(c.correlations)
+	result.TopKOverlap = mean(c.overlaps)
+	if result.CommonPairs > 0 {
+		result.MeanRateDelta = c.deltaSum / float64(result.CommonPairs)
+		result.MeanAbsRateDelta = c.absDeltaSum / float64(result.CommonPairs)
+	}
+	return result
+}
+
+// groupRates indexes matchRate by summaryId and matchedSummaryId.
+func groupRates(matchings []*Matching) map[primitive.ObjectID]map[primitive.ObjectID]int {
+	result := make(map[primitive.ObjectID]map[primitive.ObjectID]int)
+	for _, m := range matchings {
+		if result[m.SummaryId] == nil {
+			result[m.SummaryId] = make(map[primitive.ObjectID]int)
+		}
+		result[m.SummaryId][m.MatchedSummaryId] = m.MatchRate
+	}
+	return result
+}
+
+// spearman returns rank correlation of rates of ids, tied rates share the mean rank.
+func spearman(ids []primitive.ObjectID, x, y map[primitive.ObjectID]int) float64 {
+	rx, ry := ranks(ids, x), ranks(ids, y)
+	mx, my := mean(rx), mean(ry)
+	var cov, vx, vy float64
+	for i := range ids {
+		cov += (rx[i] - mx) * (ry[i] - my)
+		vx += (rx[i] - mx) * (rx[i] - mx)
+		vy += (ry[i] - my) * (ry[i] - my)
+	}
+	if vx == 0 || vy == 0 {
+		// all rates of a version are equal, rankings agree only when both are constant
+		if vx == vy {
+			return 1
+		}
+		return 0
+	}
+	return cov / math.Sqrt(vx*vy)
+}
+
+func ranks(ids []primitive.ObjectID, rates map[primitive.ObjectID]int) []float64 {
+	order := make([]int, len(ids))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return rates[ids[order[i]]] > rates[ids[order[j]]] })
+
+	result := make([]float64, len(ids))
+	for i := 0; i < len(order); {
+		j := i
+		for j < len(order) && rates[ids[order[j]]] == rates[ids[order[i]]] {
+			j++
+		}
+		// positions i..j-1 are tied, ranks are 1-based
+		for k := i; k < j; k++ {
+			result[order[k]] = float64(i+j+1) / 2
+		}
+		i = j
+	}
+	return result
+}
+
+// topKOverlap returns the part of top k matched summaries of base also found in top k of candidate.
+func topKOverlap(base, candidate map[primitive.ObjectID]int, k int) float64 {
+	baseTop, candidateTop := topMatched(base, k), topMatched(candidate, k)
+	if len(baseTop) == 0 {
+		return 0
+	}
+	found := 0
+	for id := range baseTop {
+		if candidateTop[id] {
+			found++
+		}
+	}
+	return float64(found) / float64(len(baseTop))
+}
+
+// topMatched returns k matched summaries with the highest rates, ties by lower id.
+func topMatched(rates map[primitive.ObjectID]int, k int) map[primitive.ObjectID]bool {
+	ids := make([]primitive.ObjectID, 0, len(rates))
+	for id := range rates {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return prefers(rates[ids[i]], rates[ids[j]], ids[i], ids[j]) })
+	if len(ids) > k {
+		ids = ids[:k]
+	}
+	result := make(map[primitive.ObjectID]bool, len(ids))
+	for _, id := range ids {
+		result[id] = true
+	}
+	return result
+}
+
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"testing"
+
+	iss "github.com/matryer/is"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestVersionRouting(t *testing.T) {
+	is := iss.New(t)
+	is.Equal(VersionRouting{}.Enabled(), false)
+	is.Equal(VersionRouting{Default: defaultNamespace}.Enabled(), false)
+	is.Equal(VersionRouting{Candidate: "v2"}.Enabled(), false)
+	is.Equal(VersionRouting{Default: "v1"}.Enabled(), true)
+
+	is.Equal(VersionRouting{Default: "v1", Candidate: "v2", Percent: 0}.route("p1"), "v1")
+	is.Equal(VersionRouting{Candidate: "v2", Percent: 100}.route("p1"), "v2")
+	is.Equal(VersionRouting{Candidate: "v2", Percent: 100}.route(""), "") // request without key is not split
+
+	routing := VersionRouting{Default: defaultNamespace, Candidate: "v2", Percent: 30}
+	candidates := 0
+	for i := 0; i < 1000; i++ {
+		key := primitive.NewObjectID().Hex()
+		version := routing.route(key)
+		is.Equal(routing.route(key), version) // profile stays on its version
+		if version == "v2" {
+			candidates++
+		}
+	}
+	is.True(candidates > 200 && candidates < 400) // about 30% of profiles
+
+	invalid := []VersionRouting{
+		{Default: "v 1"},
+		{Candidate: "v2/x", Percent: 10},
+		{Candidate: "v2", Percent: 101},
+		{Candidate: "v2", Percent: -1},
+		{Percent: 10},
+	}
+	for _, routing := range invalid {
+		is.True(routing.Validate() != nil) // invalid routing
+	}
+	is.NoErr(VersionRouting{Default: defaultNamespace, Candidate: "v2", Percent: 10}.Validate())
+}
+
+// compareMatchings compares matchings sorted by summary with CompareVersionIterators.
+func compareMatchings(t *testing.T, base, candidate []*Matching, topK int) VersionComparison {
+	result, err := CompareVersionIterators(context.Background(),
+		&sliceMatchingsIterator{matchings: base}, &sliceMatchingsIterator{matchings: candidate}, topK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestCompareVersionIterators(t *testing.T) {
+	is := iss.New(t)
+	s1, s2, s3 := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	m := make([]primitive.ObjectID, 4)
+	for i := range m {
+		m[i] = primitive.NewObjectID()
+	}
+	matching := func(summaryID, matchedID primitive.ObjectID, rate int) *Matching {
+		return &Matching{SummaryId: summaryID, MatchedSummaryId: matchedID, MatchRate: rate}
+	}
+	base := []*Matching{
+		matching(s1, m[0], 90), matching(s1, m[1], 60), matching(s1, m[2], 30),
+		matching(s2, m[0], 50), matching(s2, m[1], 40),
+		matching(s3, m[0], 10),
+	}
+	candidate := []*Matching{
+		// same order of s1 with lower rates, m[3] is new
+		matching(s1, m[0], 80), matching(s1, m[1], 50), matching(s1, m[2], 20), matching(s1, m[3], 10),
+		// reversed order of s2
+		matching(s2, m[0], 40), matching(s2, m[1], 60),
+	}
+
+	got := compareMatchings(t, base, candidate, 1)
+	is.Equal(got.Summaries, 2)
+	is.Equal(got.CommonPairs, 5)
+	is.Equal(got.BaseOnlyPairs, 1)
+	is.Equal(got.CandidateOnlyPairs, 1)
+	is.Equal(got.RankCorrelation, 0.0)   // mean of 1 and -1
+	is.Equal(got.TopKOverlap, 0.5)       // top of s1 is kept, top of s2 is changed
+	is.Equal(got.MeanRateDelta, -4.0)    // (-10-10-10-10+20)/5
+	is.Equal(got.MeanAbsRateDelta, 12.0) // (10+10+10+10+20)/5
+	is.Equal(got.MaxAbsRateDelta, 20)
+
+	// comparison of stored versions is the same
+	ctx := context.Background()
+	store := NewMemoryStore()
+	for version, matchings := range map[string][]*Matching{defaultNamespace: base, "v2": candidate} {
+		namespace, err := store.Namespace(version)
+		is.NoErr(err)
+		for _, m := range matchings {
+			_, err := namespace.CreateMatching(ctx, *m)
+			is.NoErr(err)
+		}
+	}
+	baseIterator, err := store.IterateMatchingsBySummary(ctx)
+	is.NoErr(err)
+	candidateStore, err := store.Namespace("v2")
+	is.NoErr(err)
+	candidateIterator, err := candidateStore.IterateMatchingsBySummary(ctx)
+	is.NoErr(err)
+	streamed, err := CompareVersionIterators(ctx, baseIterator, candidateIterator, 1)
+	is.NoErr(err)
+	is.Equal(streamed, got)
+
+	same := compareMatchings(t, base, base, 2)
+	is.Equal(same.RankCorrelation, 1.0)
+	is.Equal(same.TopKOverlap, 1.0)
+	is.Equal(same.MeanAbsRateDelta, 0.0)
+
+	// ties share the mean rank
+	ids := []primitive.ObjectID{m[0], m[1], m[2]}
+	x := map[primitive.ObjectID]int{m[0]: 50, m[1]: 50, m[2]: 10}
+	y := map[primitive.ObjectID]int{m[0]: 70, m[1]: 60, m[2]: 10}
+	is.Equal(ranks(ids, x), []float64{1.5, 1.5, 3})
+	is.True(math.Abs(spearman(ids, x, y)-math.Sqrt(0.75)) < 1e-9)
+}
+
+func TestServer_readVersions(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	summaryID, matchedID := makeObjectId(t, summaryId1), makeObjectId(t, summaryId2)
+	store.PutSummary(summaryID, SummaryDocument{"profileId": primitive.NewObjectID()})
+	candidate, err := store.Namespace("v2")
+	iss.New(t).NoErr(err)
+	for rate, s := range map[int]MatchingStore{10: store, 20: candidate} {
+		_, err := s.CreateMatching(ctx, Matching{SummaryId: summaryID, MatchedSummaryId: matchedID, MatchRate: rate})
+		iss.New(t).NoErr(err)
+	}
+
+	tests := []struct {
+		name       string
+		routing    VersionRouting
+		target     string
+		wantCode   int
+		wantRate   int
+		wantHeader string
+	}{
+		{name: "default", target: "/summary/" + summaryId1, wantCode: http.StatusOK, wantRate: 10},
+		{name: "version param", target: "/summary/" + summaryId1 + "?version=v2", wantCode: http.StatusOK, wantRate: 20, wantHeader: "v2"},
+		{name: "routed default", routing: VersionRouting{Default: "v2"}, target: "/summary/" + summaryId1, wantCode: http.StatusOK, wantRate: 20, wantHeader: "v2"},
+		{name: "routed candidate", routing: VersionRouting{Candidate: "v2", Percent: 100}, target: "/?summaryId=" + summaryId1, wantCode: http.StatusOK, wantRate: 20, wantHeader: "v2"},
+		{name: "param over routing", routing: VersionRouting{Default: "v2"}, target: "/summary/" + summaryId1 + "?version=default", wantCode: http.StatusOK, wantRate: 10, wantHeader: defaultNamespace},
+		{name: "invalid version", target: "/summary/" + summaryId1 + "?version=v2.bak", wantCode: http.StatusBadRequest},
+		{name: "unknown version", target: "/summary/" + summaryId1 + "?version=v3", wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := iss.New(t)
+			s := NewServer("test", store, WithVersionRouting(tt.routing))
+			w := doRequest(t, s, http.MethodGet, tt.target, nil)
+			is.Equal(w.Code, tt.wantCode)
+			is.Equal(w.Header().Get(headerScorerVersion), tt.wantHeader)
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			var matchings []Matching
+			is.NoErr(json.NewDecoder(w.Body).Decode(&matchings))
+			is.Equal(len(matchings), 1)
+			is.Equal(matchings[0].MatchRate, tt.wantRate)
+		})
+	}
+
+	is := iss.New(t)
+	versions, err := store.Namespaces(ctx)
+	is.NoErr(err)
+	is.Equal(versions, []string{"v2"}) // reads don't create namespaces
+
+	// writes go to the default namespace and report it while reads are routed
+	s := NewServer("test", store, WithVersionRouting(VersionRouting{Default: "v2"}))
+	w := doRequest(t, s, http.MethodPost, "/", []byte(`{"summaryId":"`+summaryId2+`","matchedSummaryId":"`+summaryId1+`","matchRate":30}`))
+	is.Equal(w.Code, http.StatusOK)
+	is.Equal(w.Header().Get(headerScorerVersion), defaultNamespace)
+}
+
+func TestServer_eraseNamespaces(t *testing.T) {
+	is := iss.New(t)
+	s, store := newTestServer(t)
+	ctx := context.Background()
+	summaryID, otherID := makeObjectId(t, summaryId1), primitive.NewObjectID()
+	for i, version := range []string{defaultNamespace, "v2", "v3"} {
+		namespace, err := store.Namespace(version)
+		is.NoErr(err)
+		_, err = namespace.CreateMatching(ctx, Matching{SummaryId: summaryID, MatchedSummaryId: otherID, MatchRate: i})
+		is.NoErr(err)
+	}
+
+	w := doRequest(t, s, http.MethodDelete, "/summary/"+summaryId1, nil)
+	is.Equal(w.Code, http.StatusOK)
+	var audit ErasureAudit
+	is.NoErr(json.NewDecoder(w.Body).Decode(&audit))
+	is.Equal(audit.DeletedMatchings, int64(3))
+	is.Equal(audit.Namespaces, map[string]int64{defaultNamespace: 1, "v2": 1, "v3": 1})
+
+	versions, err := store.Namespaces(ctx)
+	is.NoErr(err)
+	is.Equal(versions, []string{"v2", "v3"})
+	for _, version := range versions {
+		namespace, err := store.Namespace(version)
+		is.NoErr(err)
+		matchings, err := namespace.GetAllMatchings(ctx)
+		is.NoErr(err)
+		is.Equal(len(matchings), 0) // every namespace is erased
+	}
+}
+
+// failingNamespaceStore fails opening of the namespace of version failing.
+type failingNamespaceStore struct {
+	*MemoryStore
+	failing string
+}
+
+func (s failingNamespaceStore) Namespace(version string) (DataStore, error) {
+	if version == s.failing {
+		return nil, errors.New("namespace unavailable")
+	}
+	return s.MemoryStore.Namespace(version)
+}
+
+func TestEraseMatchings_partial(t *testing.T) {
+	is := iss.New(t)
+	store := NewMemoryStore()
+	ctx := context.Background()
+	summaryID := primitive.NewObjectID()
+	for _, version := range []string{defaultNamespace, "v2", "v3"} {
+		namespace, err := store.Namespace(version)
+		is.NoErr(err)
+		_, err = namespace.CreateMatching(ctx, Matching{SummaryId: summaryID, MatchedSummaryId: primitive.NewObjectID()})
+		is.NoErr(err)
+	}
+
+	audit, err := eraseMatchings(ctx, failingNamespaceStore{MemoryStore: store, failing: "v2"}, ErasureAudit{SummaryIds: []primitive.ObjectID{summaryID}})
+	is.True(err != nil)
+	is.Equal(audit.Status, ErasureStatusFailed)
+	is.Equal(audit.Error, "erase v2: namespace unavailable")
+	// other namespaces are erased and recorded
+	is.Equal(audit.Namespaces, map[string]int64{defaultNamespace: 1, "v2": 0, "v3": 1})
+	is.Equal(audit.DeletedMatchings, int64(2))
+	is.Equal(store.audits, []ErasureAudit{audit})
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,26 +11,110 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const (
-	headerContentType   = "Content-Type"
-	mimeApplicationJSON = "application/json"
+	headerContentType     = "Content-Type"
+	mimeApplicationJSON   = "application/json"
+	mimeApplicationNDJSON = "application/x-ndjson"
+	mimeMergePatchJSON    = "application/merge-patch+json"
+
+	headerTotalCount = "X-Total-Count"
+	headerNextCursor = "X-Next-Cursor"
+)
+
+// maxNDJSONLineSize limits the size of a single line of NDJSON request body.
+const maxNDJSONLineSize = 1 << 20
+
+// Limits of request body size, reading past the limit fails.
+const (
+	maxBodySize     = 1 << 20
+	maxBulkBodySize = 32 << 20
 )
 
+// errTooManyItems is returned by DecodeJSONItems when the body has more items than allowed.
+var errTooManyItems = errors.New("too many items")
+
 func DecodeJSON(r io.Reader, v interface{}) error {
 	defer io.Copy(ioutil.Discard, r)
 	return json.NewDecoder(r).Decode(v)
 }
 
+// DecodeJSONItems splits request body into raw JSON items without decoding them,
+// so each item can be decoded and validated on its own. The body is either a JSON array
+// or, when Content-Type is application/x-ndjson, a stream of newline delimited JSON values.
+// Reading stops with errTooManyItems as soon as the body has more than maxItems items.
+func DecodeJSONItems(r *http.Request, maxItems int) ([]json.RawMessage, error) {
+	defer io.Copy(ioutil.Discard, r.Body)
+
+	items := make([]json.RawMessage, 0)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get(headerContentType))
+	if mediaType != mimeApplicationNDJSON && mediaType != "application/ndjson" {
+		decoder := json.NewDecoder(r.Body)
+		if token, err := decoder.Token(); err != nil {
+			return nil, err
+		} else if token != json.Delim('[') {
+			return nil, errors.New("body must be a JSON array")
+		}
+		for decoder.More() {
+			if len(items) == maxItems {
+				return nil, errTooManyItems
+			}
+			var item json.RawMessage
+			if err := decoder.Decode(&item); err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		if _, err := decoder.Token(); err != nil {
+			return nil, err
+		}
+		return items, nil
+	}
+
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxNDJSONLineSize)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if len(items) == maxItems {
+			return nil, errTooManyItems
+		}
+		item := make(json.RawMessage, len(line))
+		copy(item, line)
+		items = append(items, item)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// limitBody fails reading of request body longer than n bytes.
+func limitBody(n int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func NewRouter() *chi.Mux {
 	r := chi.NewRouter()
 	corsMiddleware := cors.New(cors.Options{
 		AllowedOrigins: []string{"*"},
-		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders: []string{"Accept", "Authorization", headerContentType, "X-CSRF-Token"},
-		ExposedHeaders: []string{"X-Total-Count"},
+		AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders: []string{"Accept", "Authorization", headerContentType, "X-CSRF-Token", headerAPIKey, headerRequestID},
+		ExposedHeaders: []string{headerTotalCount, headerNextCursor, headerRequestID, headerScorerVersion,
+			headerRateLimitLimit, headerRateLimitRemaining, headerRateLimitReset, headerRetryAfter},
 	})
 	r.Use(corsMiddleware.Handler)
 	return r
@@ -73,3 +159,111 @@ func URLParamObjectID(r *http.Request, key string) (primitive.ObjectID, error) {
 
 	return ObjID, nil
 }
+
+// URLQueryInt returns integer value of the query parameter key, or def when parameter is not set.
+func URLQueryInt(r *http.Request, key string, def int) (int, error) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return def, nil
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return def, errors.New("invalid request data " + key)
+	}
+	return i, nil
+}
+
+// URLQueryBool returns boolean value of the query parameter key, or false when parameter is not set.
+func URLQueryBool(r *http.Request, key string) (bool, error) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return false, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, errors.New("invalid request data " + key)
+	}
+	return b, nil
+}
+
+// URLQueryObjectID returns object id passed in the query parameter key, or NilObjectID when parameter is not set.
+func URLQueryObjectID(r *http.Request, key string) (primitive.ObjectID, error) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return primitive.NilObjectID, nil
+	}
+
+	objID, err := primitive.ObjectIDFromHex(value)
+	if err != nil {
+		return primitive.NilObjectID, errors.New("invalid request data " + key)
+	}
+	return objID, nil
+}
+
+// URLQueryTime returns RFC 3339 time passed in the query parameter key, or zero time when parameter is not set.
+func URLQueryTime(r *http.Request, key string) (time.Time, error) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, errors.New("invalid request data " + key)
+	}
+	return t, nil
+}
+
+// URLQueryObjectIDs returns object ids passed in the query parameter key.
+// Ids can be passed as repeated parameter or as comma separated list.
+func URLQueryObjectIDs(r *http.Request, key string) ([]primitive.ObjectID, error) {
+	result := make([]primitive.ObjectID, 0)
+	for _, value := range r.URL.Query()[key] {
+		for _, hex := range strings.Split(value, ",") {
+			hex = strings.TrimSpace(hex)
+			if hex == "" {
+				continue
+			}
+			objID, err := primitive.ObjectIDFromHex(hex)
+			if err != nil {
+				return nil, errors.New("invalid request data " + key)
+			}
+			result = append(result, objID)
+		}
+	}
+	return result, nil
+}
+
+// MergePatch applies JSON Merge Patch (RFC 7386) patch to JSON document doc and returns patched document.
+func MergePatch(doc, patch []byte) ([]byte, error) {
+	var docValue, patchValue interface{}
+	if err := json.Unmarshal(doc, &docValue); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patch, &patchValue); err != nil {
+		return nil, err
+	}
+	return json.Marshal(mergePatch(docValue, patchValue))
+}
+
+func mergePatch(target, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObj, ok := target.(map[string]interface{})
+	if !ok {
+		targetObj = make(map[string]interface{})
+	}
+	for key, value := range patchObj {
+		if value == nil {
+			delete(targetObj, key)
+			continue
+		}
+		targetObj[key] = mergePatch(targetObj[key], value)
+	}
+	return targetObj
+}